@@ -32,6 +32,17 @@ type ConfigExecutorStruct struct {
 	Comment  string
 	Internal bool
 	Global   bool // one instance can be shared accross all users
+	Cols     int  // initial pty width. 0 : use default
+	Rows     int  // initial pty height. 0 : use default
+	Record   bool // record sessions of the executor (asciicast format)
+	Timeout  int  // seconds. timeout of cmdline in oneshot mode. 0: use default; negative: no timeout
+	// "KEY=VALUE" env entries of executor processes. Not a map because viper lowercases map keys
+	Env       []string
+	SecretEnv []string // same as Env, but values are treated as secrets and redacted
+	NoRedact  bool     // do not redact secrets and credentials in output
+	// seconds. Auto delete bot output messages of executor after it. 0: never
+	DeleteAfter int
+	DeleteCmd   bool // also delete user's cmdline messages after DeleteAfter
 }
 
 // Securely publish intranet (e.g.: 127.0.0.1) service to tg user
@@ -44,13 +55,24 @@ type ConfigServiceStruct struct {
 }
 
 type ConfigStruct struct {
-	ShellExecutor        string // by default, use "cmd /C" on windows, "/bin/bash -c" on other platforms.
-	ShellExecutorButtons []string
-	TelegramToken        string // tg bot token
-	Cmds                 []*ConfigCmdStruct
-	Executors            []*ConfigExecutorStruct
-	Services             []*ConfigServiceStruct
-	Whitelist            []int64
+	ShellExecutor            string // by default, use "cmd /C" on windows, "/bin/bash -c" on other platforms.
+	ShellExecutorButtons     []string
+	PtyCols                  int      // initial width of internal pty executor
+	PtyRows                  int      // initial height of internal pty executor
+	ShellExecutorTimeout     int      // timeout of default executor. See ConfigExecutorStruct.Timeout
+	ShellExecutorEnv         []string // env of internal executors. See ConfigExecutorStruct.Env
+	ShellExecutorNoRedact    bool     // See ConfigExecutorStruct.NoRedact
+	ShellExecutorDeleteAfter int      // See ConfigExecutorStruct.DeleteAfter
+	ShellExecutorDeleteCmd   bool
+	RedactPatterns           []string // regexp patterns masked in output, in addition to builtin credential formats
+	TelegramToken            string   // tg bot token
+	TelegramApiUrl           string   // Bot API server url. Set to use a self-hosted telegram-bot-api server
+	TelegramApiLocal         bool     // the self-hosted Bot API server runs in --local mode on the same host
+	UploadRoots              []string // if not empty, files sent to bot can only be saved inside these dirs
+	Cmds                     []*ConfigCmdStruct
+	Executors                []*ConfigExecutorStruct
+	Services                 []*ConfigServiceStruct
+	Whitelist                []int64
 	// should be same as server's OpenSSH HostKeyAlgorithms. Default values can be found using `man ssh_config`.
 	// Note it's not same as `ssh -Q HostKeyAlgorithms`,
 	// which outputs all available algorithms, not actual used algorithms.
@@ -116,6 +138,48 @@ func (ecs *ConfigExecutorStruct) Desc() (desc string) {
 	return
 }
 
+// get the initial pty size of executor
+func (ecs *ConfigExecutorStruct) PtySize() (cols int, rows int) {
+	cols, rows = ecs.Cols, ecs.Rows
+	if cols <= 0 {
+		cols = constants.PTY_W
+	}
+	if rows <= 0 {
+		rows = constants.PTY_H
+	}
+	return
+}
+
+// Return all configured "KEY=VALUE" env entries of executor processes
+func (ecs *ConfigExecutorStruct) Environ() (env []string) {
+	env = append(env, ecs.Env...)
+	env = append(env, ecs.SecretEnv...)
+	return
+}
+
+// Return the secret values of executor, which should never be displayed
+func (ecs *ConfigExecutorStruct) Secrets() (secrets []string) {
+	if ecs.Secret != "" {
+		secrets = append(secrets, ecs.Secret)
+	}
+	for _, entry := range ecs.SecretEnv {
+		if _, value, _ := strings.Cut(entry, "="); value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+	return
+}
+
+// get the timeout seconds of cmdline in oneshot mode. 0 means no timeout
+func (ecs *ConfigExecutorStruct) GetTimeout() int {
+	if ecs.Timeout < 0 {
+		return 0
+	} else if ecs.Timeout == 0 {
+		return constants.DEFAULT_TIMEOUT
+	}
+	return ecs.Timeout
+}
+
 func (sc *ConfigServiceStruct) GetName() string {
 	if sc.Name != "" {
 		return sc.Name
@@ -123,6 +187,30 @@ func (sc *ConfigServiceStruct) GetName() string {
 	return sc.Hostname
 }
 
+// Base url of Bot API server, without trailing slash
+func (cs *ConfigStruct) ApiUrl() string {
+	if cs.TelegramApiUrl == "" {
+		return constants.TG_API_URL
+	}
+	return strings.TrimSuffix(cs.TelegramApiUrl, "/")
+}
+
+// Max size of file which bot can send
+func (cs *ConfigStruct) UploadLimit() int64 {
+	if cs.TelegramApiLocal {
+		return constants.TG_LOCAL_FILE_LIMIT
+	}
+	return constants.TG_UPLOAD_LIMIT
+}
+
+// Max size of file sent to bot which bot can download
+func (cs *ConfigStruct) DownloadLimit() int64 {
+	if cs.TelegramApiLocal {
+		return constants.TG_LOCAL_FILE_LIMIT
+	}
+	return constants.TG_DOWNLOAD_LIMIT
+}
+
 func (cs *ConfigStruct) ResetSecret() error {
 	secret := make([]byte, 32)
 	if _, err := rand.Read(secret); err != nil {
@@ -219,6 +307,16 @@ func InitConfig() error {
 	ConfigData.sideeffect()
 	DefaultExecutorConfig.Buttons = ConfigData.ShellExecutorButtons
 	PtyExecutorConfig.Buttons = ConfigData.ShellExecutorButtons
+	PtyExecutorConfig.Cols, PtyExecutorConfig.Rows = ConfigData.PtyCols, ConfigData.PtyRows
+	DefaultExecutorConfig.Timeout = ConfigData.ShellExecutorTimeout
+	DefaultExecutorConfig.Env = ConfigData.ShellExecutorEnv
+	PtyExecutorConfig.Env = ConfigData.ShellExecutorEnv
+	DefaultExecutorConfig.NoRedact = ConfigData.ShellExecutorNoRedact
+	PtyExecutorConfig.NoRedact = ConfigData.ShellExecutorNoRedact
+	DefaultExecutorConfig.DeleteAfter = ConfigData.ShellExecutorDeleteAfter
+	PtyExecutorConfig.DeleteAfter = ConfigData.ShellExecutorDeleteAfter
+	DefaultExecutorConfig.DeleteCmd = ConfigData.ShellExecutorDeleteCmd
+	PtyExecutorConfig.DeleteCmd = ConfigData.ShellExecutorDeleteCmd
 	return nil
 }
 
@@ -261,6 +359,16 @@ func Reload() error {
 	ConfigData.sideeffect()
 	DefaultExecutorConfig.Buttons = ConfigData.ShellExecutorButtons
 	PtyExecutorConfig.Buttons = ConfigData.ShellExecutorButtons
+	PtyExecutorConfig.Cols, PtyExecutorConfig.Rows = ConfigData.PtyCols, ConfigData.PtyRows
+	DefaultExecutorConfig.Timeout = ConfigData.ShellExecutorTimeout
+	DefaultExecutorConfig.Env = ConfigData.ShellExecutorEnv
+	PtyExecutorConfig.Env = ConfigData.ShellExecutorEnv
+	DefaultExecutorConfig.NoRedact = ConfigData.ShellExecutorNoRedact
+	PtyExecutorConfig.NoRedact = ConfigData.ShellExecutorNoRedact
+	DefaultExecutorConfig.DeleteAfter = ConfigData.ShellExecutorDeleteAfter
+	PtyExecutorConfig.DeleteAfter = ConfigData.ShellExecutorDeleteAfter
+	DefaultExecutorConfig.DeleteCmd = ConfigData.ShellExecutorDeleteCmd
+	PtyExecutorConfig.DeleteCmd = ConfigData.ShellExecutorDeleteCmd
 	return nil
 }
 
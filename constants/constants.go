@@ -6,6 +6,8 @@ const TG_FILES_MAX = 99        // if >= 100, also need to set sprintf wide forma
 const TG_TEXT_LIMIT = 4096     // tg accepts message of max 4096 UTF-8 characters
 const PTY_H = 100              // PTY height. Some applications refuse to work if width or height is 0
 const PTY_W = 100              // PTY width.
+const DEFAULT_TIMEOUT = 30     // Seconds. Default timeout of cmdline in oneshot executors
+const SIGNAL_GRACE_PERIOD = 3  // Seconds. Wait before sending next (stronger) signal to a cancelled process
 const TIMEOUT_MESSAGE = 60 * 3 // Seconds. Ignore tg message which arrives too late
 const DEFAULT_SERVICES_PORT = 8085
 const DEFAULT_SERVICES_ADDR = "0.0.0.0"
@@ -13,3 +15,8 @@ const SERVICE_AUTH_PREFIX = "/__auth__/"
 const SERVICE_COOKIE_NAME = "_ts_token"
 const SERVICE_AUTHTOKEN_MAXAGE = 1800
 const SERVICE_COOKIE_MAXAGE = 86400 * 400
+const TG_API_URL = "https://api.telegram.org"
+const TG_UPLOAD_LIMIT = 50 * 1024 * 1024       // Bytes. Max size of file uploaded by bot via Bot API
+const TG_DOWNLOAD_LIMIT = 20 * 1024 * 1024     // Bytes. Max size of file downloaded by bot via Bot API
+const TG_LOCAL_FILE_LIMIT = 2000 * 1024 * 1024 // Bytes. Max size of file via local mode Bot API server
+const TG_HTTP_TIMEOUT = 60 * 30                // Seconds. Timeout of Bot API requests, which may upload large files
@@ -3,6 +3,7 @@ package executor
 import (
 	"context"
 	"fmt"
+	"os/exec"
 
 	"github.com/sagan/tgshell/config"
 )
@@ -22,6 +23,30 @@ type Executor interface {
 	Close() // Chan() may close async after Close() return.
 }
 
+// Optional interface of executors which may run in a pty (pseudo terminal)
+type Resizer interface {
+	Size() (cols int, rows int) // return 0 if it's not running in a pty
+	Resize(cols int, rows int) error
+}
+
+// Optional interface of executors which run every cmdline in a new local process
+type Commander interface {
+	// return a not started cmd which runs cmdline, with env ("KEY=VALUE" entries) added to executor's env
+	Command(cmdline string, env []string) (*exec.Cmd, error)
+}
+
+// Optional interface of executors which may run every cmdline separately,
+// returning it's whole output from Exec() and a exit status footer in the end
+type Oneshoter interface {
+	Oneshot() bool
+}
+
+// Return whether e runs in oneshot mode
+func IsOneshot(e Executor) bool {
+	oneshoter, ok := e.(Oneshoter)
+	return ok && oneshoter.Oneshot()
+}
+
 type RegInfo struct {
 	Name    string
 	Usage   string
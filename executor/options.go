@@ -0,0 +1,56 @@
+package executor
+
+import (
+	"context"
+	"io"
+)
+
+// Env of a directory exported to oneshot processes. Files written to it are sent back to user
+const OUT_DIR_ENV = "TGSHELL_OUT"
+
+// Per-invocation options of Exec(), passed via ctx. Executors ignore the options they do not support.
+type ExecOptions struct {
+	Timeout   *int              // seconds. nil: use executor default; 0: no timeout
+	OnExit    func(*ExecResult) // called after cmdline exits, before the output channel is closed
+	Stdin     io.Reader         // stdin of cmdline. If it's an io.Closer, the executor closes it when cmdline exits
+	OutDir    string            // exported as OUT_DIR_ENV env to process, if not empty
+	Env       []string          // extra "KEY=VALUE" env entries of process, overriding executor config env
+	NoHistory bool              // do not add cmdline to history
+}
+
+type execOptionsKey struct{}
+
+// Return a copy of ctx which carries options
+func WithExecOptions(ctx context.Context, options *ExecOptions) context.Context {
+	return context.WithValue(ctx, execOptionsKey{}, options)
+}
+
+// Return the options carried by ctx. Never return nil
+func GetExecOptions(ctx context.Context) *ExecOptions {
+	if options, ok := ctx.Value(execOptionsKey{}).(*ExecOptions); ok && options != nil {
+		return options
+	}
+	return &ExecOptions{}
+}
+
+// Call OnExit callback, if any
+func (options *ExecOptions) Exit(result *ExecResult) {
+	if options.OnExit != nil {
+		options.OnExit(result)
+	}
+}
+
+// Close Stdin, if it's an io.Closer
+func (options *ExecOptions) CloseStdin() {
+	if closer, ok := options.Stdin.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// Return timeout seconds of a invocation. 0 means no timeout
+func (options *ExecOptions) GetTimeout(defaultTimeout int) int {
+	if options.Timeout != nil {
+		return *options.Timeout
+	}
+	return defaultTimeout
+}
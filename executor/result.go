@@ -0,0 +1,85 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sagan/tgshell/util"
+)
+
+const (
+	KILLED_TIMEOUT = "timeout"
+	KILLED_CANCEL  = "cancelled"
+)
+
+// Summary of a finished cmdline in oneshot mode
+type ExecResult struct {
+	Cmdline    string
+	ExitCode   int    // -1 if the process did not exit normally (e.g. terminated by signal)
+	Status     string // human readable exit status, e.g. "exit 0", "signal: killed"
+	Killed     string // why it's killed by tgshell: "" (not killed), KILLED_TIMEOUT or KILLED_CANCEL
+	Duration   time.Duration
+	UserTime   time.Duration // 0 if unknown
+	SystemTime time.Duration // 0 if unknown
+	MaxRSS     int64         // bytes. 0 if unknown
+}
+
+// Create result of a local process from it's state. state may be nil if process failed to be waited
+func NewProcessResult(cmdline string, state *os.ProcessState, err error, killed string,
+	duration time.Duration) *ExecResult {
+	result := &ExecResult{
+		Cmdline:  cmdline,
+		ExitCode: -1,
+		Killed:   killed,
+		Duration: duration,
+	}
+	if state == nil {
+		result.Status = fmt.Sprintf("error: %v", err)
+		return result
+	}
+	result.ExitCode = state.ExitCode()
+	if result.ExitCode >= 0 {
+		result.Status = fmt.Sprintf("exit %d", result.ExitCode)
+	} else {
+		result.Status = state.String()
+	}
+	result.UserTime = state.UserTime()
+	result.SystemTime = state.SystemTime()
+	result.MaxRSS = util.MaxRSS(state)
+	return result
+}
+
+// Success reports whether the cmdline exited with status 0
+func (result *ExecResult) Success() bool {
+	return result.ExitCode == 0 && result.Killed == ""
+}
+
+// Compact one line footer, e.g.: "✓ exit 0 · 1.2s · user 0.8s sys 0.1s · rss 12.3MiB"
+func (result *ExecResult) String() string {
+	mark := "✗"
+	if result.Success() {
+		mark = "✓"
+	}
+	status := result.Status
+	if result.Killed != "" {
+		status += fmt.Sprintf(" (%s)", result.Killed)
+	}
+	parts := []string{mark + " " + status, formatDuration(result.Duration)}
+	if result.UserTime > 0 || result.SystemTime > 0 {
+		parts = append(parts, fmt.Sprintf("user %s sys %s",
+			formatDuration(result.UserTime), formatDuration(result.SystemTime)))
+	}
+	if result.MaxRSS > 0 {
+		parts = append(parts, "rss "+util.BytesSize(float64(result.MaxRSS)))
+	}
+	return strings.Join(parts, " · ")
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Millisecond * 100).String()
+}
@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/acarl005/stripansi"
@@ -55,7 +56,6 @@ type optionsStruct struct {
 
 type Shell struct {
 	executorConfig *config.ConfigExecutorStruct
-	TimeoutSecond  int
 	executor       string
 	executorArgs   []string
 	cancelSignal   chan struct{}
@@ -64,6 +64,8 @@ type Shell struct {
 	output         chan string
 	pty            bool
 	ptmx           *os.File
+	cols           int
+	rows           int
 	options        *optionsStruct
 }
 
@@ -117,11 +119,13 @@ func (s *Shell) Open() error {
 	if s.pty {
 		var err error
 		c := exec.Command(s.executor, s.executorArgs...)
+		c.Env = append(os.Environ(), s.executorConfig.Environ()...)
 		if s.ptmx, err = pty.Start(c); err != nil {
 			close(s.output)
 			return fmt.Errorf("failed to create pty: %v", err)
 		}
-		if err = pty.Setsize(s.ptmx, &pty.Winsize{Rows: constants.PTY_H, Cols: constants.PTY_W}); err != nil {
+		cols, rows := s.executorConfig.PtySize()
+		if err = s.Resize(cols, rows); err != nil {
 			close(s.output)
 			return fmt.Errorf("failed to set pty size: %v", err)
 		}
@@ -198,7 +202,6 @@ func NewExecutor(executorConfig *config.ConfigExecutorStruct, extraOption string
 	}
 	return &Shell{
 		executorConfig: executorConfig,
-		TimeoutSecond:  30,
 		options:        options,
 		executor:       executor,
 		executorArgs:   executorArgs,
@@ -211,9 +214,12 @@ func NewExecutor(executorConfig *config.ConfigExecutorStruct, extraOption string
 
 func (s *Shell) Exec(ctx context.Context, cmdline string, isRaw bool) (output chan string) {
 	if !isRaw {
-		s.history = util.AppendUniqueCapSlice(s.history, constants.MAX_HISTORY, cmdline)
+		if !executor.GetExecOptions(ctx).NoHistory {
+			s.history = util.AppendUniqueCapSlice(s.history, constants.MAX_HISTORY, cmdline)
+		}
 		if !s.pty {
 			if data, handled := s.runBuiltin(ctx, cmdline); handled {
+				executor.GetExecOptions(ctx).CloseStdin()
 				output = make(chan string, 1)
 				output <- data
 				close(output)
@@ -235,23 +241,24 @@ func (s *Shell) exec(ctx context.Context, cmdline string, outputMeta bool) (outp
 	output = make(chan string)
 
 	go func(shell *Shell, cmdline string, output chan<- string) {
-		ctx, timeoutCancel := context.WithTimeout(ctx, time.Second*time.Duration(s.TimeoutSecond))
-		ctx, cancel := context.WithCancel(ctx)
-		defer timeoutCancel()
+		var cancel context.CancelFunc
+		if timeout := executor.GetExecOptions(ctx).GetTimeout(shell.executorConfig.GetTimeout()); timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, time.Second*time.Duration(timeout))
+		} else {
+			ctx, cancel = context.WithCancel(ctx)
+		}
 		defer cancel()
 		defer close(output)
-		var args []string
-		args = append(args, shell.executorArgs...)
-		if !s.options.ParseArgs {
-			args = append(args, cmdline)
-		} else {
-			if cmdargs, err := shlex.Split(cmdline); err != nil {
-				args = append(args, cmdline)
-			} else {
-				args = append(args, cmdargs...)
-			}
+		options := executor.GetExecOptions(ctx)
+		defer options.CloseStdin()
+		cmd := exec.Command(shell.executor, shell.args(cmdline)...)
+		util.SetProcessGroup(cmd)
+		cmd.Stdin = options.Stdin
+		cmd.Env = append(os.Environ(), shell.executorConfig.Environ()...)
+		cmd.Env = append(cmd.Env, options.Env...)
+		if options.OutDir != "" {
+			cmd.Env = append(cmd.Env, executor.OUT_DIR_ENV+"="+options.OutDir)
 		}
-		cmd := exec.CommandContext(ctx, shell.executor, args...)
 		stdout, err := cmd.StdoutPipe()
 		if err != nil {
 			if outputMeta {
@@ -260,13 +267,33 @@ func (s *Shell) exec(ctx context.Context, cmdline string, outputMeta bool) (outp
 			return
 		}
 		cmd.Stderr = cmd.Stdout
-		cmd.Start()
+		if err := cmd.Start(); err != nil {
+			if outputMeta {
+				output <- fmt.Sprintf("Failed to start process, error=%v", err)
+			}
+			return
+		}
+		startedAt := time.Now()
+		exited := make(chan struct{})
+		watched := make(chan struct{})
+		var killed atomic.Value
 		go func(signal <-chan struct{}) {
+			defer close(watched)
 			select {
 			case <-signal:
-				cancel()
+				killed.Store(executor.KILLED_CANCEL)
 			case <-ctx.Done():
+				// process may have exited at the same time; do not kill it's background children then
+				select {
+				case <-exited:
+					return
+				default:
+				}
+				killed.Store(executor.KILLED_TIMEOUT)
+			case <-exited:
+				return
 			}
+			util.TerminateProcessGroup(cmd.Process.Pid, exited)
 		}(shell.cancelSignal)
 		buf := make([]byte, 10240)
 		for {
@@ -277,14 +304,46 @@ func (s *Shell) exec(ctx context.Context, cmdline string, outputMeta bool) (outp
 			output <- string(buf[:i])
 		}
 		err = cmd.Wait()
+		close(exited)
+		<-watched // stop the watcher before ctx is cancelled on return
+		reason, _ := killed.Load().(string)
+		result := executor.NewProcessResult(cmdline, cmd.ProcessState, err, reason, time.Since(startedAt))
 		if outputMeta {
-			output <- fmt.Sprintf("Process '%s' exitted, error=%v", cmdline, err)
+			output <- result.String()
 		}
+		options.Exit(result)
 	}(s, cmdline, output)
 
 	return
 }
 
+// Return the args of interpreter to run cmdline
+func (s *Shell) args(cmdline string) (args []string) {
+	args = append(args, s.executorArgs...)
+	if !s.options.ParseArgs {
+		args = append(args, cmdline)
+	} else {
+		if cmdargs, err := shlex.Split(cmdline); err != nil {
+			args = append(args, cmdline)
+		} else {
+			args = append(args, cmdargs...)
+		}
+	}
+	return
+}
+
+// Command implements executor.Commander.
+func (s *Shell) Command(cmdline string, env []string) (*exec.Cmd, error) {
+	if s.pty {
+		return nil, fmt.Errorf("executor '%s' is not in oneshot mode", s.Name())
+	}
+	cmd := exec.Command(s.executor, s.args(strings.TrimSpace(cmdline))...)
+	cmd.Env = append(os.Environ(), s.executorConfig.Environ()...)
+	cmd.Env = append(cmd.Env, env...)
+	util.SetProcessGroup(cmd)
+	return cmd, nil
+}
+
 func (s *Shell) runBuiltin(ctx context.Context, cmdline string) (output string, handled bool) {
 	if i := strings.Index(cmdline, ";"); i != -1 && i < len(cmdline)-1 {
 		return
@@ -323,6 +382,28 @@ cancel:
 	}
 }
 
+// Oneshot implements executor.Oneshoter.
+func (s *Shell) Oneshot() bool {
+	return !s.pty
+}
+
+// Size implements executor.Resizer.
+func (s *Shell) Size() (cols int, rows int) {
+	return s.cols, s.rows
+}
+
+// Resize implements executor.Resizer.
+func (s *Shell) Resize(cols int, rows int) error {
+	if !s.pty || s.ptmx == nil {
+		return fmt.Errorf("not a pty executor")
+	}
+	if err := pty.Setsize(s.ptmx, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)}); err != nil {
+		return err
+	}
+	s.cols, s.rows = cols, rows
+	return nil
+}
+
 func (s *Shell) Close() {
 	if s.pty {
 		s.ptmx.Close()
@@ -330,3 +411,6 @@ func (s *Shell) Close() {
 }
 
 var _ executor.Executor = (*Shell)(nil)
+var _ executor.Resizer = (*Shell)(nil)
+var _ executor.Commander = (*Shell)(nil)
+var _ executor.Oneshoter = (*Shell)(nil)
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os/user"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/acarl005/stripansi"
@@ -29,26 +31,32 @@ Flags (Most are same as OpenSSH 'ssh' command)
 * -p int : SSH server port (Default 22)
 * -R string, -L string, -D string : Set up port forwarding
 * -T : Disable pseudo-terminal allocation
+* -N : Do not open a shell or execute a command. Only keep the connection and port forwards alive
 * -i string : The identity (private key) (default to ~/.ssh/id_*)
-* -o string : SSH option
+* -o string : SSH option. Supported: ServerAliveInterval, ServerAliveCountMax, ConnectTimeout, ControlMaster
 * --ts-insecure : Accept unknown ssh server
+* --ts-oneshot : Run every cmdline in a new ssh session (no pty), with it's own output and exit status.
+  If [command] is set, it's used as the prefix of every cmdline
 E.g.: /addexecutor myssh ssh example.com
 By default it only allows public-key authentication and uses OpenSSH ~/.ssh/id_* identity files.` + "\n" +
 	"To use password authentication, type '/setsecret <name> <secret>' to set the password. " +
 	"The public key of the ssh server will be checked against ~/.ssh/known_hosts file."
 
 var permanentButtons = []string{"^C", "^Z", "pwd"}
+var tunnelButtons = []string{"status"}
 
 // Most flags use OpenSSH "ssh" command flags. See "man ssh"
 type optionsStruct struct {
 	IdentityFiles   []string `short:"i"`          // -i identity_file : The default is ~/.ssh/id_dsa
 	Port            int      `short:"p"`          // -p port : Port to connect to on the remote host.
 	NoPty           bool     `short:"T"`          // -T : Disable pseudo-terminal allocation.
+	NoShell         bool     `short:"N"`          // -N : Do not execute a remote command. Only forward ports
 	LocalForwards   []string `short:"L"`          // -L [bind_address:]port:host:hostport...
 	RemoteForwards  []string `short:"R"`          // -R [bind_address:]port:host:hostport...
 	DynamicForwards []string `short:"D"`          // -D [bind_address:]port
 	SshOptions      []string `short:"o"`          // -o option : only some ssh options are supported
 	Insecure        bool     `long:"ts-insecure"` // Skip server public key verification
+	Oneshot         bool     `long:"ts-oneshot"`  // Run every cmdline in a new session
 }
 
 type Ssh struct {
@@ -58,10 +66,19 @@ type Ssh struct {
 	password       string
 	command        string
 	options        *optionsStruct
+	client         *sshutil.SharedClient
 	session        *ssh.Session
+	forwards       []*sshutil.Forward
+	connectedAt    time.Time
+	closed         chan struct{}
+	closeOnce      sync.Once
+	disconnectOnce sync.Once
 	stdin          io.WriteCloser
+	cancelSignal   chan struct{}
 	history        []string
 	pty            bool
+	cols           int
+	rows           int
 	out            chan string // ssh stdout+stderr
 }
 
@@ -85,7 +102,12 @@ func (s *Ssh) Clear() {
 
 // Buttons implements executor.Executor.
 func (s *Ssh) Buttons() (buttons []string) {
-	if s.command != "" {
+	if s.command != "" && !s.options.Oneshot {
+		return
+	}
+	if s.options.NoShell {
+		buttons = append(buttons, tunnelButtons...)
+		buttons = append(buttons, executor.GlobalButtons...)
 		return
 	}
 	historyBtns := util.Filter(s.history, func(cmdline string) bool {
@@ -113,6 +135,7 @@ func (s *Ssh) Open() error {
 	serverAliveInterval := 15
 	serverAliveCountMax := 5
 	connectionTimeout := 20
+	controlMaster := true
 	for _, sshOption := range s.options.SshOptions {
 		var err error
 		args := strings.Split(sshOption, "=")
@@ -126,6 +149,16 @@ func (s *Ssh) Open() error {
 				serverAliveCountMax, err = strconv.Atoi(args[1])
 			case "ConnectTimeout":
 				connectionTimeout, err = strconv.Atoi(args[1])
+			case "ControlMaster":
+				// Share one connection to same (user, host, port, auth) between executor sessions
+				switch strings.ToLower(args[1]) {
+				case "yes", "auto":
+					controlMaster = true
+				case "no":
+					controlMaster = false
+				default:
+					err = fmt.Errorf("invalid ControlMaster value '%s'", args[1])
+				}
 			default:
 				err = fmt.Errorf("unsupported ssh option '%s'", args[0])
 			}
@@ -141,92 +174,58 @@ func (s *Ssh) Open() error {
 		CheckKnownHosts: !s.options.Insecure,
 		ConnectTimeout:  connectionTimeout,
 	}
-	err := sshutil.CreateSshClient(con, s.hostname, fmt.Sprint(s.options.Port),
-		s.username, s.password, s.options.IdentityFiles)
+	client, err := sshutil.AcquireSshClient(con, s.hostname, fmt.Sprint(s.options.Port),
+		s.username, s.password, s.options.IdentityFiles, controlMaster, serverAliveInterval, serverAliveCountMax)
 	if err != nil {
 		close(s.out)
 		return fmt.Errorf("failed to create ssh client: %v", err)
 	}
-
-	for _, localForward := range s.options.LocalForwards {
-		args := strings.Split(localForward, ":")
-		if len(args) == 1 {
-			err = fmt.Errorf("invalid local_forward '%s'", localForward)
-		} else if len(args) == 2 {
-			// -L local_socket:remote_socket
-			// 80:80
-			err = con.TCPLocalForward(args[0], args[1])
-		} else if len(args) == 3 {
-			// 80:1.2.3.4:80
-			err = con.TCPLocalForward(args[0], strings.Join(args[1:], ":"))
-		} else {
-			// 127.0.0.1:80:1.2.3.4:80
-			err = con.TCPLocalForward(strings.Join(args[:2], ":"), strings.Join(args[2:], ":"))
-		}
-		if err != nil {
-			break
-		}
-	}
-	if err == nil {
-		for _, remoteForward := range s.options.RemoteForwards {
-			args := strings.Split(remoteForward, ":")
-			if len(args) == 1 {
-				// -R [bind_address:]port
-				// 80
-				err = con.TCPReverseDynamicForward("0.0.0.0", args[0])
-			} else if len(args) == 2 {
-				// -R [bind_address:]port
-				// 127.0.0.1:80
-				err = con.TCPReverseDynamicForward("0.0.0.0", args[1])
-			} else if len(args) == 3 {
-				// -R [bind_address:]port:host:hostport
-				// 888:1.2.3.4:80
-				err = con.TCPRemoteForward(strings.Join(args[1:], ":"), args[0])
-			} else {
-				// -R [bind_address:]port:host:hostport
-				// 0.0.0.0:80:0.0.0.0:80
-				err = con.TCPRemoteForward(strings.Join(args[2:], ":"), strings.Join(args[:2], ":"))
-			}
-			if err != nil {
-				break
-			}
-		}
+	s.client = client
+	s.connectedAt = time.Now()
+	if err = s.forward(); err != nil {
+		s.disconnect()
+		close(s.out)
+		return fmt.Errorf("failed to create port forward: %v", err)
 	}
-	if err == nil {
-		for _, dynamicForward := range s.options.DynamicForwards {
-			args := strings.Split(dynamicForward, ":")
-			if len(args) == 1 {
-				// 80
-				err = con.TCPDynamicForward("0.0.0.0", args[0])
-			} else if len(args) == 2 {
-				// localhost:80
-				err = con.TCPDynamicForward(args[0], args[1])
+
+	if s.options.NoShell || s.options.Oneshot {
+		go func() {
+			defer close(s.out)
+			defer s.disconnect()
+			if s.options.NoShell {
+				s.out <- s.status()
 			} else {
-				err = fmt.Errorf("invalid dynamic_forward '%s'", dynamicForward)
+				s.out <- fmt.Sprintf("Connected to %s@%s:%d (oneshot mode). Every cmdline runs in a new session",
+					s.username, s.hostname, s.options.Port)
 			}
-			if err != nil {
-				break
+			select {
+			case <-s.client.Done():
+				if s.options.NoShell {
+					log.Printf("ssh tunnel exit, err=%v", s.client.Err())
+				}
+				s.out <- fmt.Sprintf("Connection to %s lost: %v", s.hostname, s.client.Err())
+			case <-s.closed:
 			}
-		}
-	}
-	if err != nil {
-		close(s.out)
-		return fmt.Errorf("failed to create port forward: %v", err)
+		}()
+		return nil
 	}
 
-	session, err := con.CreateSession()
+	session, err := s.client.NewSession()
 	if err != nil {
+		s.disconnect()
 		close(s.out)
 		return fmt.Errorf("failed to create ssh session: %v", err)
 	}
 	s.stdin, err = session.StdinPipe()
 	if err != nil {
 		session.Close()
+		s.disconnect()
 		close(s.out)
 		return fmt.Errorf("failed to pipe stdin: %v", err)
 	}
 	session.Stdout = s
 	session.Stderr = s
+	s.setenv(session, s.executorConfig.Environ())
 
 	if !s.options.NoPty {
 		modes := ssh.TerminalModes{
@@ -234,60 +233,220 @@ func (s *Ssh) Open() error {
 			ssh.TTY_OP_ISPEED: 14400,
 			ssh.TTY_OP_OSPEED: 14400,
 		}
-		if err := session.RequestPty("xterm", constants.PTY_H, constants.PTY_W, modes); err != nil {
+		cols, rows := s.executorConfig.PtySize()
+		if err := session.RequestPty("xterm", rows, cols, modes); err != nil {
 			s.out <- fmt.Sprintf("Warning: failed to request pty: %v\n", err)
 		} else {
 			s.pty = true
+			s.cols, s.rows = cols, rows
 		}
 	}
 
 	s.session = session
-	go func(session *ssh.Session, command string, aliveInterval int, aliveMax int) {
+	go func(session *ssh.Session, command string) {
 		defer close(s.out)
+		defer s.disconnect()
+		// the connection is kept alive by the client. If it's lost, end this session
+		sessionDone := make(chan struct{})
+		defer close(sessionDone)
+		go func() {
+			select {
+			case <-s.client.Done():
+				session.Close()
+			case <-sessionDone:
+			}
+		}()
 		if command != "" {
 			err := session.Run(command)
 			log.Printf("ssh session run %s, err=%v", command, err)
 			session.Close()
-			return
+		} else {
+			err := session.Shell()
+			log.Printf("ssh session start, err=%v", err)
+			if err == nil {
+				err = session.Wait()
+				log.Printf("ssh session exit, err=%v", err)
+			}
 		}
-		err := session.Shell()
-		log.Printf("ssh session start, err=%v", err)
-		if err == nil && aliveInterval > 0 {
-			go func(session *ssh.Session) {
-				i := 0
-				for {
-					_, err := session.SendRequest("keepalive", true, nil)
-					if err == nil {
-						i = 0
-					} else {
-						i += 1
-					}
-					if aliveMax <= i {
-						session.Close()
-						return
-					}
-					time.Sleep(time.Duration(aliveInterval) * time.Second)
-				}
-			}(session)
+		select {
+		case <-s.client.Done():
+			s.out <- fmt.Sprintf("Connection to %s lost: %v", s.hostname, s.client.Err())
+		default:
 		}
-		err = session.Wait()
-		log.Printf("ssh session exit, err=%v", err)
-	}(s.session, s.command, serverAliveInterval, serverAliveCountMax)
+	}(s.session, s.command)
 	return nil
 }
 
 func (s *Ssh) Cancel() {
-	if s.pty {
+	if s.options.Oneshot {
+	cancel:
+		for {
+			select {
+			case s.cancelSignal <- struct{}{}:
+			default:
+				break cancel
+			}
+		}
+		return
+	}
+	if s.pty && s.session != nil {
 		// 0x03 : Ctrl + C
 		s.exec(context.Background(), string([]byte{3}))
 	}
 }
 
 func (s *Ssh) Close() {
-	s.session.Close()
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	if s.session != nil {
+		s.session.Close()
+	}
+}
+
+// Size implements executor.Resizer.
+func (s *Ssh) Size() (cols int, rows int) {
+	return s.cols, s.rows
+}
+
+// Resize implements executor.Resizer. Send a "window-change" request to the live session.
+func (s *Ssh) Resize(cols int, rows int) error {
+	if !s.pty || s.session == nil {
+		return fmt.Errorf("the ssh session does not have a pty")
+	}
+	if err := s.session.WindowChange(rows, cols); err != nil {
+		return err
+	}
+	s.cols, s.rows = cols, rows
+	return nil
+}
+
+// Set up all -L, -R and -D port forwards over s.client
+func (s *Ssh) forward() (err error) {
+	var forward *sshutil.Forward
+	for _, localForward := range s.options.LocalForwards {
+		spec := "-L " + localForward
+		args := strings.Split(localForward, ":")
+		if len(args) == 1 {
+			err = fmt.Errorf("invalid local_forward '%s'", localForward)
+		} else if len(args) == 2 {
+			// -L local_socket:remote_socket
+			// 80:80
+			forward, err = sshutil.LocalForward(s.client.Client, spec, bindAddr(args[0]), bindAddr(args[1]))
+		} else if len(args) == 3 {
+			// 80:1.2.3.4:80
+			forward, err = sshutil.LocalForward(s.client.Client, spec, bindAddr(args[0]), strings.Join(args[1:], ":"))
+		} else {
+			// 127.0.0.1:80:1.2.3.4:80
+			forward, err = sshutil.LocalForward(s.client.Client, spec,
+				strings.Join(args[:2], ":"), strings.Join(args[2:], ":"))
+		}
+		if err != nil {
+			return
+		}
+		s.forwards = append(s.forwards, forward)
+	}
+	for _, remoteForward := range s.options.RemoteForwards {
+		spec := "-R " + remoteForward
+		args := strings.Split(remoteForward, ":")
+		if len(args) == 1 {
+			// -R [bind_address:]port
+			// 80
+			forward, err = sshutil.ReverseDynamicForward(s.client.Client, spec, net.JoinHostPort("0.0.0.0", args[0]))
+		} else if len(args) == 2 {
+			// -R [bind_address:]port
+			// 127.0.0.1:80
+			forward, err = sshutil.ReverseDynamicForward(s.client.Client, spec, net.JoinHostPort("0.0.0.0", args[1]))
+		} else if len(args) == 3 {
+			// -R [bind_address:]port:host:hostport
+			// 888:1.2.3.4:80
+			forward, err = sshutil.RemoteForward(s.client.Client, spec, bindAddr(args[0]), strings.Join(args[1:], ":"))
+		} else {
+			// -R [bind_address:]port:host:hostport
+			// 0.0.0.0:80:0.0.0.0:80
+			forward, err = sshutil.RemoteForward(s.client.Client, spec,
+				strings.Join(args[:2], ":"), strings.Join(args[2:], ":"))
+		}
+		if err != nil {
+			return
+		}
+		s.forwards = append(s.forwards, forward)
+	}
+	for _, dynamicForward := range s.options.DynamicForwards {
+		spec := "-D " + dynamicForward
+		args := strings.Split(dynamicForward, ":")
+		if len(args) == 1 {
+			// 80
+			forward, err = sshutil.DynamicForward(s.client.Client, spec, net.JoinHostPort("0.0.0.0", args[0]))
+		} else if len(args) == 2 {
+			// localhost:80
+			forward, err = sshutil.DynamicForward(s.client.Client, spec, net.JoinHostPort(args[0], args[1]))
+		} else {
+			err = fmt.Errorf("invalid dynamic_forward '%s'", dynamicForward)
+		}
+		if err != nil {
+			return
+		}
+		s.forwards = append(s.forwards, forward)
+	}
+	return
+}
+
+// Close all port forwards and release the ssh client
+func (s *Ssh) disconnect() {
+	s.disconnectOnce.Do(func() {
+		for _, forward := range s.forwards {
+			forward.Close()
+		}
+		if s.client != nil {
+			s.client.Release()
+		}
+	})
+}
+
+// Connection status and port forward stats
+func (s *Ssh) status() string {
+	str := fmt.Sprintf("Tunnel %s@%s:%d (no shell)\n", s.username, s.hostname, s.options.Port)
+	str += fmt.Sprintf("Connected: %s (%s)\n", s.connectedAt.Format(time.DateTime),
+		time.Since(s.connectedAt).Truncate(time.Second))
+	if users := s.client.Refs(); users > 1 {
+		str += fmt.Sprintf("Connection: shared by %d executor sessions\n", users)
+	}
+	if aliveAt := s.client.AliveAt(); !aliveAt.IsZero() {
+		str += fmt.Sprintf("Keepalive: last replied %s ago\n", time.Since(aliveAt).Truncate(time.Second))
+	}
+	str += fmt.Sprintf("Forwards (%d):\n", len(s.forwards))
+	for _, forward := range s.forwards {
+		str += forward.String() + "\n"
+	}
+	return str
+}
+
+// Oneshot implements executor.Oneshoter.
+func (s *Ssh) Oneshot() bool {
+	return s.options.Oneshot
 }
 
 func (s *Ssh) Exec(ctx context.Context, cmdline string, isRaw bool) (output chan string) {
+	if s.options.NoShell {
+		// Any input just displays the tunnel status
+		output = make(chan string, 1)
+		output <- s.status()
+		close(output)
+		return
+	}
+	if s.options.Oneshot {
+		if isRaw {
+			output = make(chan string, 1)
+			output <- "Raw input is not supported in oneshot mode"
+			close(output)
+			return
+		}
+		if !executor.GetExecOptions(ctx).NoHistory {
+			s.history = util.AppendUniqueCapSlice(s.history, constants.MAX_HISTORY, cmdline)
+		}
+		return s.run(ctx, cmdline)
+	}
 	if !isRaw {
 		s.history = util.AppendUniqueCapSlice(s.history, constants.MAX_HISTORY, cmdline)
 		cmdline += "\n"
@@ -296,6 +455,105 @@ func (s *Ssh) Exec(ctx context.Context, cmdline string, isRaw bool) (output chan
 	return
 }
 
+// Request env of session. The server may reject the names not listed in it's AcceptEnv
+func (s *Ssh) setenv(session *ssh.Session, env []string) {
+	for _, entry := range env {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			if err := session.Setenv(name, value); err != nil {
+				log.Printf("ssh server rejected env %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// Run cmdline in a new session (oneshot mode) and return it's output.
+// On timeout or cancel, send SIGINT to the remote process, then SIGKILL if it does not exit soon.
+func (s *Ssh) run(ctx context.Context, cmdline string) (output chan string) {
+	if s.command != "" {
+		cmdline = s.command + " " + cmdline
+	}
+	output = make(chan string)
+	go func() {
+		defer close(output)
+		var cancel context.CancelFunc
+		if timeout := executor.GetExecOptions(ctx).GetTimeout(s.executorConfig.GetTimeout()); timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, time.Second*time.Duration(timeout))
+		} else {
+			ctx, cancel = context.WithCancel(ctx)
+		}
+		defer cancel()
+		session, err := s.client.NewSession()
+		if err != nil {
+			output <- fmt.Sprintf("Failed to create ssh session: %v", err)
+			return
+		}
+		defer session.Close()
+		s.setenv(session, append(s.executorConfig.Environ(), executor.GetExecOptions(ctx).Env...))
+		writer := &chanWriter{output}
+		session.Stdout = writer
+		session.Stderr = writer
+		if err := session.Start(cmdline); err != nil {
+			output <- fmt.Sprintf("Failed to run '%s': %v", cmdline, err)
+			return
+		}
+		startedAt := time.Now()
+		done := make(chan error, 1)
+		go func() {
+			done <- session.Wait()
+		}()
+		killed := ""
+		select {
+		case err = <-done:
+		case <-s.cancelSignal:
+			killed = executor.KILLED_CANCEL
+		case <-ctx.Done():
+			killed = executor.KILLED_TIMEOUT
+		}
+		if killed != "" {
+			exited := false
+			for _, signal := range []ssh.Signal{ssh.SIGINT, ssh.SIGTERM, ssh.SIGKILL} {
+				session.Signal(signal)
+				select {
+				case err = <-done:
+					exited = true
+				case <-time.After(constants.SIGNAL_GRACE_PERIOD * time.Second):
+				}
+				if exited {
+					break
+				}
+			}
+			if !exited {
+				// the server may not support signals
+				session.Close()
+				err = <-done
+			}
+		}
+		// remote process resource usage is not available over ssh
+		result := &executor.ExecResult{
+			Cmdline:  cmdline,
+			ExitCode: 0,
+			Status:   "exit 0",
+			Killed:   killed,
+			Duration: time.Since(startedAt),
+		}
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			if exitErr.Signal() != "" {
+				result.ExitCode = -1
+				result.Status = "signal: " + exitErr.Signal()
+			} else {
+				result.ExitCode = exitErr.ExitStatus()
+				result.Status = fmt.Sprintf("exit %d", result.ExitCode)
+			}
+		} else if err != nil {
+			result.ExitCode = -1
+			result.Status = fmt.Sprintf("error: %v", err)
+		}
+		output <- result.String()
+		executor.GetExecOptions(ctx).Exit(result)
+	}()
+	return
+}
+
 func (s *Ssh) exec(ctx context.Context, cmdline string) {
 	s.stdin.Write([]byte(cmdline))
 }
@@ -316,6 +574,24 @@ func (s *Ssh) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// io.Writer which sends everything written to a channel
+type chanWriter struct {
+	c chan<- string
+}
+
+func (w *chanWriter) Write(p []byte) (n int, err error) {
+	w.c <- string(p)
+	return len(p), nil
+}
+
+// Add "localhost" host to a port-only address. Keep other addresses unchanged
+func bindAddr(addr string) string {
+	if _, err := strconv.Atoi(addr); err == nil {
+		return net.JoinHostPort("localhost", addr)
+	}
+	return addr
+}
+
 func NewExecutor(executorConfig *config.ConfigExecutorStruct, extraOption string) (executor.Executor, error) {
 	executorConfigStr := executorConfig.Config
 	if executorConfigStr != "" && extraOption != "" {
@@ -338,6 +614,9 @@ func NewExecutor(executorConfig *config.ConfigExecutorStruct, extraOption string
 	if len(args) > 1 {
 		command = args[1]
 	}
+	if options.NoShell && (command != "" || options.Oneshot) {
+		return nil, fmt.Errorf("-N can not be used with a command or --ts-oneshot")
+	}
 	if i := strings.Index(destination, "@"); i != -1 {
 		username = destination[:i]
 		hostname = destination[i+1:]
@@ -372,8 +651,12 @@ func NewExecutor(executorConfig *config.ConfigExecutorStruct, extraOption string
 		command:        command,
 		options:        options,
 		session:        nil,
+		closed:         make(chan struct{}),
+		cancelSignal:   make(chan struct{}),
 		out:            make(chan string, 1),
 	}, nil
 }
 
 var _ executor.Executor = (*Ssh)(nil)
+var _ executor.Resizer = (*Ssh)(nil)
+var _ executor.Oneshoter = (*Ssh)(nil)
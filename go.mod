@@ -6,6 +6,7 @@ replace github.com/ThalesIgnite/crypto11 v1.2.5 => github.com/blacknon/crypto11
 
 require (
 	github.com/acarl005/stripansi v0.0.0-20180116102854-5a71ef0e047d
+	github.com/armon/go-socks5 v0.0.0-20160902184237-e75332964ef5
 	github.com/blacknon/go-sshlib v0.1.10
 	github.com/creack/pty v1.1.21
 	github.com/golang-jwt/jwt/v5 v5.2.0
@@ -41,7 +42,6 @@ require (
 	github.com/Azure/go-ansiterm v0.0.0-20230124172434-306776ec8161 // indirect
 	github.com/ScaleFT/sshkeys v1.2.0 // indirect
 	github.com/ThalesIgnite/crypto11 v1.2.5 // indirect
-	github.com/armon/go-socks5 v0.0.0-20160902184237-e75332964ef5 // indirect
 	github.com/dchest/bcrypt_pbkdf v0.0.0-20150205184540-83f37f9c154a // indirect
 	github.com/lunixbochs/vtclean v1.0.0 // indirect
 	github.com/miekg/pkcs11 v1.1.1 // indirect
@@ -0,0 +1,289 @@
+// Background jobs: detached local processes which are not bound to any tg message and have no timeout.
+// Their output is saved to a file and can be streamed to subscribers (attached chats).
+package job
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sagan/tgshell/config"
+	"github.com/sagan/tgshell/executor"
+	"github.com/sagan/tgshell/util"
+)
+
+const JOBS_DIR = "jobs" // relative to config.ConfigPath
+const MAX_JOBS = 50     // max jobs kept in list. Older finished jobs and their output files are removed
+
+type Job struct {
+	Id          int
+	Cmdline     string
+	Chatid      int64 // owning chatid
+	Pid         int
+	StartedAt   time.Time
+	EndedAt     time.Time
+	OutputPath  string
+	cmd         *exec.Cmd
+	err         error
+	done        chan struct{}
+	killed      atomic.Bool // killed by user
+	output      *os.File
+	mu          sync.Mutex
+	subscribers map[int64]chan string // chatid => attached output channel
+	listeners   []func(data string)
+}
+
+var (
+	jobs   []*Job
+	lastId int
+	mu     sync.Mutex
+)
+
+func jobsDir() string {
+	return path.Join(config.ConfigPath, JOBS_DIR)
+}
+
+// Start cmd as a background job. onExit is called in a new goroutine after the job exits.
+func Start(cmd *exec.Cmd, cmdline string, chatid int64, onExit func(job *Job)) (*Job, error) {
+	if err := os.MkdirAll(jobsDir(), 0700); err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	lastId++
+	id := lastId
+	mu.Unlock()
+	outputPath := path.Join(jobsDir(), fmt.Sprintf("%d_%s.log", id, time.Now().Format("20060102-150405")))
+	output, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		Id:          id,
+		Cmdline:     cmdline,
+		Chatid:      chatid,
+		OutputPath:  outputPath,
+		cmd:         cmd,
+		done:        make(chan struct{}),
+		output:      output,
+		subscribers: map[int64]chan string{},
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		output.Close()
+		return nil, err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		output.Close()
+		return nil, err
+	}
+	job.Pid = cmd.Process.Pid
+	job.StartedAt = time.Now()
+	mu.Lock()
+	jobs = append(jobs, job)
+	// remove oldest finished jobs and their output files
+	for len(jobs) > MAX_JOBS {
+		i := slices.IndexFunc(jobs, func(j *Job) bool { return !j.Running() })
+		if i == -1 {
+			break
+		}
+		os.Remove(jobs[i].OutputPath)
+		jobs = slices.Delete(jobs, i, i+1)
+	}
+	mu.Unlock()
+	go func() {
+		job.pipe(stdout)
+		err := cmd.Wait()
+		job.mu.Lock()
+		job.err = err
+		job.EndedAt = time.Now()
+		job.output.Close()
+		for chatid, subscriber := range job.subscribers {
+			close(subscriber)
+			delete(job.subscribers, chatid)
+		}
+		job.mu.Unlock()
+		close(job.done)
+		if onExit != nil {
+			onExit(job)
+		}
+	}()
+	return job, nil
+}
+
+// Copy process output to output file and subscribers.
+// Slow subscribers miss data instead of blocking the process.
+func (job *Job) pipe(stdout io.Reader) {
+	buf := make([]byte, 10240)
+	for {
+		i, err := stdout.Read(buf)
+		if i > 0 {
+			data := string(buf[:i])
+			job.mu.Lock()
+			job.output.WriteString(data)
+			for _, subscriber := range job.subscribers {
+				select {
+				case subscriber <- data:
+				default:
+				}
+			}
+			for _, listener := range job.listeners {
+				listener(data)
+			}
+			job.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func Get(id int) *Job {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, job := range jobs {
+		if job.Id == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// Return all jobs, oldest first
+func List() []*Job {
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Clone(jobs)
+}
+
+func (job *Job) Running() bool {
+	select {
+	case <-job.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Return a channel which is closed after the job exits
+func (job *Job) Done() <-chan struct{} {
+	return job.done
+}
+
+// Human readable status, e.g.: "running", "exit 0", "signal: killed"
+func (job *Job) Status() string {
+	if job.Running() {
+		return "running"
+	}
+	if exitErr, ok := job.err.(*exec.ExitError); ok {
+		if exitErr.Exited() {
+			return fmt.Sprintf("exit %d", exitErr.ExitCode())
+		}
+		return exitErr.String()
+	} else if job.err != nil {
+		return job.err.Error()
+	}
+	return "exit 0"
+}
+
+// Return the exit summary of job, or nil if it's still running
+func (job *Job) Result() *executor.ExecResult {
+	if job.Running() {
+		return nil
+	}
+	killed := ""
+	if job.killed.Load() {
+		killed = executor.KILLED_CANCEL
+	}
+	return executor.NewProcessResult(job.Cmdline, job.cmd.ProcessState, job.err, killed, job.EndedAt.Sub(job.StartedAt))
+}
+
+// Duration since job started, until now or job ended
+func (job *Job) Runtime() time.Duration {
+	if job.Running() {
+		return time.Since(job.StartedAt).Truncate(time.Second)
+	}
+	return job.EndedAt.Sub(job.StartedAt).Truncate(time.Second)
+}
+
+// One line summary, e.g.: "3  pid 1234  running  1m2s  make all"
+func (job *Job) String() string {
+	return fmt.Sprintf("%d  pid %d  %s  %s  %s", job.Id, job.Pid, job.Status(), job.Runtime(), job.Cmdline)
+}
+
+// Stream new output of job to the returned channel, which is closed after job exits or Detach() is called.
+func (job *Job) Attach(chatid int64) (<-chan string, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if !job.Running() {
+		return nil, fmt.Errorf("job %d has exited", job.Id)
+	}
+	if job.subscribers[chatid] != nil {
+		return nil, fmt.Errorf("already attached to job %d", job.Id)
+	}
+	subscriber := make(chan string, 100)
+	job.subscribers[chatid] = subscriber
+	return subscriber, nil
+}
+
+// Call listener with every new output of job, synchronously. listener must not block
+func (job *Job) Listen(listener func(data string)) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.listeners = append(job.listeners, listener)
+}
+
+func (job *Job) Attached(chatid int64) bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.subscribers[chatid] != nil
+}
+
+func (job *Job) Detach(chatid int64) error {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if subscriber := job.subscribers[chatid]; subscriber == nil {
+		return fmt.Errorf("not attached to job %d", job.Id)
+	} else {
+		close(subscriber)
+		delete(job.subscribers, chatid)
+	}
+	return nil
+}
+
+// Return at most last n bytes of job output
+func (job *Job) Tail(n int64) (string, error) {
+	file, err := os.Open(job.OutputPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if stat.Size() > n {
+		if _, err := file.Seek(-n, io.SeekEnd); err != nil {
+			return "", err
+		}
+	}
+	data, err := io.ReadAll(file)
+	return strings.ToValidUTF8(string(data), ""), err
+}
+
+// Send SIGINT, SIGTERM, then SIGKILL to the process group of job, until it exits
+func (job *Job) Kill() error {
+	if !job.Running() {
+		return fmt.Errorf("job %d has exited", job.Id)
+	}
+	job.killed.Store(true)
+	go util.TerminateProcessGroup(job.Pid, job.done)
+	return nil
+}
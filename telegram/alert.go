@@ -0,0 +1,170 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sagan/tgshell/job"
+)
+
+const ALERT_MIN_INTERVAL = 10 * time.Second // min interval between notifications of same alert
+const ALERT_LINE_LIMIT = 500                // max chars of quoted line
+
+// Notify chat when a line of session or job output matches pattern
+type alertRule struct {
+	Id         int
+	Pattern    *regexp.Regexp
+	Chatid     int64
+	Session    string // session name. Empty if it's a job alert
+	JobId      int
+	notifiedAt time.Time
+	suppressed int // matches not notified because of ALERT_MIN_INTERVAL
+}
+
+var (
+	alertRules     []*alertRule
+	lastAlertId    int
+	alertRulesLock sync.Mutex
+)
+
+func addAlert(chatid int64, session string, jobId int, pattern string) (*alertRule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	alertRulesLock.Lock()
+	defer alertRulesLock.Unlock()
+	lastAlertId++
+	rule := &alertRule{Id: lastAlertId, Pattern: re, Chatid: chatid, Session: session, JobId: jobId}
+	alertRules = append(alertRules, rule)
+	return rule, nil
+}
+
+// Delete alert of chat. Return false if not found
+func delAlert(chatid int64, id int) bool {
+	alertRulesLock.Lock()
+	defer alertRulesLock.Unlock()
+	index := slices.IndexFunc(alertRules, func(rule *alertRule) bool { return rule.Id == id && rule.Chatid == chatid })
+	if index == -1 {
+		return false
+	}
+	alertRules = slices.Delete(alertRules, index, index+1)
+	return true
+}
+
+// Delete all alerts of a closed session or exited job
+func clearAlerts(session string, jobId int) {
+	alertRulesLock.Lock()
+	defer alertRulesLock.Unlock()
+	alertRules = slices.DeleteFunc(alertRules, func(rule *alertRule) bool {
+		return rule.Session == session && rule.JobId == jobId
+	})
+}
+
+// Human readable alerts list of chat
+func alertsList(chatid int64) string {
+	alertRulesLock.Lock()
+	defer alertRulesLock.Unlock()
+	data := fmt.Sprintf("Alerts\n%s\n\n", ALERTS_TIP)
+	for _, rule := range alertRules {
+		if rule.Chatid != chatid {
+			continue
+		}
+		if rule.Session != "" {
+			data += fmt.Sprintf("%-2d  %s  %s\n", rule.Id, rule.Session, rule.Pattern)
+		} else {
+			data += fmt.Sprintf("%-2d  job %d  %s\n", rule.Id, rule.JobId, rule.Pattern)
+		}
+	}
+	return data
+}
+
+// Check lines of session or job output against alerts, send notifications of matched ones
+func checkAlerts(session string, jobId int, lines []string, messenger chan<- *TgGlobalMsg) {
+	if len(lines) == 0 {
+		return
+	}
+	var msgs []*TgGlobalMsg
+	alertRulesLock.Lock()
+	for _, rule := range alertRules {
+		if rule.Session != session || rule.JobId != jobId {
+			continue
+		}
+		for _, line := range lines {
+			if !rule.Pattern.MatchString(line) {
+				continue
+			}
+			if time.Since(rule.notifiedAt) < ALERT_MIN_INTERVAL {
+				rule.suppressed++
+				continue
+			}
+			source := session
+			if session == "" {
+				source = fmt.Sprintf("job %d", jobId)
+			}
+			if runes := []rune(line); len(runes) > ALERT_LINE_LIMIT {
+				line = string(runes[:ALERT_LINE_LIMIT]) + "..."
+			}
+			data := fmt.Sprintf("🔔 Alert %d (%s) matched in %s:\n> %s", rule.Id, rule.Pattern, source, line)
+			if rule.suppressed > 0 {
+				data += fmt.Sprintf("\n(%d more matches before)", rule.suppressed)
+			}
+			msgs = append(msgs, &TgGlobalMsg{Type: TYPE_GLOBAL, Chatid: rule.Chatid, Data: data})
+			rule.notifiedAt = time.Now()
+			rule.suppressed = 0
+		}
+	}
+	alertRulesLock.Unlock()
+	// messenger may be read by the caller goroutine, so send async
+	if len(msgs) > 0 {
+		go func() {
+			for _, msg := range msgs {
+				messenger <- msg
+			}
+		}()
+	}
+}
+
+// Split a output stream into complete lines
+type lineScanner struct {
+	partial string
+}
+
+// Return complete lines in data, keeping the trailing partial line for next call
+func (ls *lineScanner) Lines(data string) (lines []string) {
+	data = ls.partial + data
+	index := strings.LastIndex(data, "\n")
+	if index == -1 {
+		ls.partial = data
+		if len(ls.partial) > ALERT_LINE_LIMIT*10 {
+			// never ending line
+			lines, ls.partial = []string{ls.partial}, ""
+		}
+		return
+	}
+	ls.partial = data[index+1:]
+	for _, line := range strings.Split(data[:index], "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+// Scan output of job for alerts. Output is redacted following session, so alert quotes do not leak secrets
+func listenJobAlerts(j *job.Job, session *TgExecutorSession, messenger chan<- *TgGlobalMsg) {
+	scanner := &lineScanner{}
+	redactor := session.newRedactor()
+	j.Listen(func(data string) {
+		if session.Redacting() {
+			data = redactor.Redact(data)
+		} else {
+			data = redactor.Flush() + data
+		}
+		checkAlerts("", j.Id, scanner.Lines(data), messenger)
+	})
+}
@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/shlex"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/util"
+	"github.com/sagan/tgshell/util/archive"
+)
+
+type getfileOptions struct {
+	Recursive bool
+	Split     bool
+	archive.Options
+	Path string
+}
+
+// Parse "/getfile [-r] [--split] [-f zip|tar.gz] [-i glob]... [-x glob]... <path>" payload
+func parseGetfileOptions(payload string) (*getfileOptions, error) {
+	args, err := shlex.Split(payload)
+	if err != nil {
+		return nil, err
+	}
+	options := &getfileOptions{Options: archive.Options{Format: archive.FORMAT_ZIP}}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if options.Path != "" {
+				return nil, fmt.Errorf("only one path is allowed")
+			}
+			options.Path = arg
+			continue
+		}
+		switch arg {
+		case "--":
+			if i+2 != len(args) || options.Path != "" {
+				return nil, fmt.Errorf("only one path is allowed")
+			}
+			options.Path = args[i+1]
+			i++
+		case "-r", "--recursive":
+			options.Recursive = true
+		case "-s", "--split":
+			options.Split = true
+		case "-f", "--format", "-i", "--include", "-x", "--exclude":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			switch arg {
+			case "-f", "--format":
+				switch args[i] {
+				case "zip":
+					options.Format = archive.FORMAT_ZIP
+				case "tgz", "tar.gz":
+					options.Format = archive.FORMAT_TARGZ
+				default:
+					return nil, fmt.Errorf("unsupported format '%s'", args[i])
+				}
+			case "-i", "--include":
+				options.Include = append(options.Include, args[i])
+			default:
+				options.Exclude = append(options.Exclude, args[i])
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %s", arg)
+		}
+	}
+	if options.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	return options, nil
+}
+
+// Archive dir and send it as document(s). Archive larger than split part size is split into
+// parts, each of which is sent as soon as it's complete. Cancelled by ctx
+func sendArchive(ctx context.Context, C tele.Context, dir string, options *archive.Options) {
+	name := path.Base(dir) + "." + options.Format
+	C.Reply(fmt.Sprintf("Archiving %s to %s. To cancel, send /cancel", dir, name))
+	sent := 0
+	sw, err := util.NewSplitWriter(name, splitPartSize(), func(name string, file string) error {
+		if err := C.Reply(&tele.Document{File: localFile(file), FileName: name}); err != nil {
+			return fmt.Errorf("failed to send %s: %w", name, err)
+		}
+		sent++
+		return nil
+	})
+	if err != nil {
+		C.Reply(fmt.Sprintf("Failed to archive %s: %v", dir, err))
+		return
+	}
+	if err = archive.Write(ctx, sw, dir, options); err != nil {
+		sw.Abort()
+	} else {
+		err = sw.Close()
+	}
+	if err != nil {
+		C.Reply(fmt.Sprintf("Failed to archive %s: %v", dir, err))
+	} else if sent > 1 {
+		if err := sendSplitManifest(C, sw.Manifest()); err != nil {
+			C.Reply(fmt.Sprintf("Failed to send manifest: %v", err))
+		}
+		C.Reply(fmt.Sprintf("Sent %s in %d parts of at most %s. To reassemble, send the manifest and all parts "+
+			"to bot, or concatenate them in order: cat %s.* > %s", name, sent, util.BytesSize(float64(splitPartSize())),
+			name, name))
+	}
+}
@@ -0,0 +1,128 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/executor"
+	"github.com/sagan/tgshell/util"
+)
+
+const COLLECT_MAX_FILES = 10               // max files sent back after a cmdline
+const COLLECT_MAX_PHOTO = 10 * 1024 * 1024 // images larger than this are sent as documents
+
+var photoExts = []string{".jpg", ".jpeg", ".png", ".webp"}
+
+// Collect files produced by a cmdline: all files written to the TGSHELL_OUT dir,
+// and files matching the --collect glob which are modified during the cmdline
+type collector struct {
+	outDir    string
+	glob      string
+	startedAt time.Time
+}
+
+// A relative glob is resolved against the current dir of tgshell
+func newCollector(glob string) (*collector, error) {
+	if glob != "" {
+		glob = absPath(glob)
+	}
+	outDir, err := os.MkdirTemp("", "tgshell-out-")
+	if err != nil {
+		return nil, err
+	}
+	return &collector{outDir: outDir, glob: glob, startedAt: time.Now()}, nil
+}
+
+func (c *collector) files() (files []string) {
+	filepath.WalkDir(c.outDir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if c.glob != "" {
+		matches, err := filepath.Glob(c.glob)
+		if err != nil {
+			log.Printf("invalid collect glob %s: %v", c.glob, err)
+		}
+		// file system mtime may have low precision
+		since := c.startedAt.Truncate(time.Second)
+		for _, match := range matches {
+			if stat, err := os.Stat(match); err == nil && stat.Mode().IsRegular() && !stat.ModTime().Before(since) {
+				if abspath, err := filepath.Abs(match); err == nil && !slices.Contains(files, abspath) {
+					files = append(files, abspath)
+				}
+			}
+		}
+	}
+	return
+}
+
+// Send collected files to chat, then remove the TGSHELL_OUT dir.
+// The sent messages are deleted after the ttl of command output, if any
+func (c *collector) send(C tele.Context, deleter *messageDeleter) {
+	defer os.RemoveAll(c.outDir)
+	ttl, _ := C.Get("ttl").(int)
+	reply := func(what interface{}) error {
+		msg, err := C.Bot().Reply(C.Message(), what)
+		deleter.Schedule(msg, ttl)
+		return err
+	}
+	files := c.files()
+	if len(files) > COLLECT_MAX_FILES {
+		reply(fmt.Sprintf("%d files produced, only sending first %d", len(files), COLLECT_MAX_FILES))
+		files = files[:COLLECT_MAX_FILES]
+	}
+	for _, file := range files {
+		stat, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		filename := filepath.Base(file)
+		caption := fmt.Sprintf("%s (%s)", filename, util.BytesSize(float64(stat.Size())))
+		if slices.Contains(photoExts, strings.ToLower(filepath.Ext(file))) && stat.Size() <= COLLECT_MAX_PHOTO {
+			err = reply(&tele.Photo{File: localFile(file), Caption: caption})
+		} else {
+			err = reply(&tele.Document{File: localFile(file), FileName: filename, Caption: caption})
+		}
+		if err != nil {
+			reply(fmt.Sprintf("Failed to send %s: %v", file, err))
+		}
+	}
+}
+
+// Run cmdline like command_run, exporting TGSHELL_OUT dir to the process (if the executor supports it).
+// After it exits, send the collected files back. Input to a pty executor is not collected unless --collect is set
+func command_run_collect(ctx context.Context, C tele.Context, session *TgExecutorSession,
+	output chan<- string, deleter *messageDeleter, options *runOptions, cmdline string) {
+	if !executor.IsOneshot(session.Executor) && options.Collect == "" {
+		command_run(executor.WithExecOptions(ctx, &options.ExecOptions), session, output, cmdline)
+		return
+	}
+	collector, err := newCollector(options.Collect)
+	if err != nil {
+		output <- fmt.Sprintf("Failed to create %s dir: %v", executor.OUT_DIR_ENV, err)
+		close(output)
+		return
+	}
+	options.OutDir = collector.outDir
+	options.Env = append(options.Env, session.Environ()...)
+	cmdOutput := make(chan string)
+	command_run(executor.WithExecOptions(ctx, &options.ExecOptions), session, cmdOutput, cmdline)
+	go func() {
+		for data := range cmdOutput {
+			output <- data
+		}
+		close(output)
+		collector.send(C, deleter)
+	}()
+}
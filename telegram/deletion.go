@@ -0,0 +1,182 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/config"
+)
+
+const DELETIONS_FILE = "deletions.json" // relative to config.ConfigPath
+
+const DELETION_RETRY_DELAY = 5        // seconds. Delay before first retry of a failed deletion, doubled each time
+const DELETION_RETRY_MAX_DELAY = 3600 // seconds
+const DELETION_MAX_ATTEMPTS = 20
+
+// A message to be deleted at a scheduled time
+type scheduledDeletion struct {
+	Chatid    int64
+	MessageId int
+	At        int64 // unix timestamp
+	Attempts  int   // failed deletion attempts
+}
+
+// Delete messages after their TTL. The schedule is persisted to a file so it survives restart.
+type messageDeleter struct {
+	bot       *tele.Bot
+	deletions []*scheduledDeletion
+	mu        sync.Mutex
+}
+
+func deletionsFile() string {
+	return path.Join(config.ConfigPath, DELETIONS_FILE)
+}
+
+// Create a deleter, loading the persisted schedule, and run it until ctx is done
+func newMessageDeleter(ctx context.Context, bot *tele.Bot) *messageDeleter {
+	d := &messageDeleter{bot: bot}
+	if data, err := os.ReadFile(deletionsFile()); err == nil {
+		if err := json.Unmarshal(data, &d.deletions); err != nil {
+			log.Printf("Failed to parse %s: %v", deletionsFile(), err)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.run()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return d
+}
+
+// Schedule deletion of msg after ttl seconds. Do nothing if msg is nil or ttl <= 0
+func (d *messageDeleter) Schedule(msg *tele.Message, ttl int) {
+	if msg == nil || ttl <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deletions = append(d.deletions, &scheduledDeletion{
+		Chatid:    msg.Chat.ID,
+		MessageId: msg.ID,
+		At:        time.Now().Unix() + int64(ttl),
+	})
+	d.save()
+}
+
+// Set the ttl of command output in c (read by messenger), from options or the executor config.
+// Also schedule deletion of the user's command message if required. For a callback, the message
+// is the bot's menu, which is kept
+func (d *messageDeleter) applyTTL(c tele.Context, executorName string, options *runOptions) {
+	ttl, deleteCmd := options.TTL, options.TTLCmd
+	if executorConfig := config.GetExecutor(executorName); executorConfig != nil {
+		if ttl == 0 {
+			ttl = executorConfig.DeleteAfter
+		}
+		deleteCmd = deleteCmd || executorConfig.DeleteCmd
+	}
+	if ttl <= 0 {
+		return
+	}
+	c.Set("ttl", ttl)
+	if deleteCmd && c.Callback() == nil {
+		d.Schedule(c.Message(), ttl)
+	}
+}
+
+// Return the ttl of messages of executor, 0 if they should not be deleted
+func executorTTL(executorName string) int {
+	if executorConfig := config.GetExecutor(executorName); executorConfig != nil {
+		return executorConfig.DeleteAfter
+	}
+	return 0
+}
+
+// Delete all due messages. Failed deletions are retried with backoff, unless the error is permanent,
+// e.g. the message is already deleted or too old (Telegram refuses to delete messages older than 48 hours)
+func (d *messageDeleter) run() {
+	now := time.Now().Unix()
+	d.mu.Lock()
+	var due []*scheduledDeletion
+	d.deletions = slices.DeleteFunc(d.deletions, func(deletion *scheduledDeletion) bool {
+		if deletion.At <= now {
+			due = append(due, deletion)
+			return true
+		}
+		return false
+	})
+	d.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+	var retries []*scheduledDeletion
+	for i, deletion := range due {
+		err := d.bot.Delete(&tele.StoredMessage{MessageID: strconv.Itoa(deletion.MessageId), ChatID: deletion.Chatid})
+		if err == nil {
+			continue
+		}
+		var floodErr tele.FloodError
+		if errors.As(err, &floodErr) {
+			// rate limited: postpone this and the remaining ones without counting an attempt
+			for _, deletion := range due[i:] {
+				deletion.At = now + int64(max(floodErr.RetryAfter, 1))
+			}
+			retries = append(retries, due[i:]...)
+			break
+		}
+		deletion.Attempts++
+		if permanentDeleteError(err) || deletion.Attempts >= DELETION_MAX_ATTEMPTS {
+			log.Printf("Failed to delete message %d of chat %d: %v", deletion.MessageId, deletion.Chatid, err)
+			continue
+		}
+		deletion.At = now + int64(min(DELETION_RETRY_DELAY<<(deletion.Attempts-1), DELETION_RETRY_MAX_DELAY))
+		retries = append(retries, deletion)
+	}
+	d.mu.Lock()
+	d.deletions = append(d.deletions, retries...)
+	d.save()
+	d.mu.Unlock()
+}
+
+// Whether a deletion error will not be resolved by retrying
+func permanentDeleteError(err error) bool {
+	var tgErr *tele.Error
+	if errors.As(err, &tgErr) {
+		return tgErr.Code == 400 || tgErr.Code == 403
+	}
+	// errors not known by telebot, e.g. "message can't be deleted for everyone"
+	return strings.Contains(err.Error(), "can't be deleted") || strings.Contains(err.Error(), "not found")
+}
+
+// Persist schedule. Must be called with mu locked
+func (d *messageDeleter) save() {
+	data, err := json.Marshal(d.deletions)
+	if err != nil {
+		return
+	}
+	tmpfile := deletionsFile() + ".tmp"
+	if err := os.WriteFile(tmpfile, data, 0600); err != nil {
+		log.Printf("Failed to save deletions: %v", err)
+		return
+	}
+	if err := os.Rename(tmpfile, deletionsFile()); err != nil {
+		log.Printf("Failed to save deletions: %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package telegram
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/shlex"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/constants"
+	"github.com/sagan/tgshell/util"
+	"github.com/sagan/tgshell/util/redact"
+)
+
+// First line prefix of the ForceReply prompt with the content to edit
+const PROMPT_EDIT = "Edit - "
+
+const EDIT_MAX_SIZE = 1024 * 1024                    // larger files are refused
+const EDIT_MAX_CHARS = constants.TG_TEXT_LIMIT - 600 // max chars of content sent for editing
+const EDIT_DIFF_CONTEXT = 3                          // context lines of diff
+const EDIT_BACKUP_SUFFIX = ".bak"
+const EDIT_SECRET_LINES_SHOWN = 10 // max numbers of lines with secrets shown when refusing to edit
+
+const USAGE_EDIT = "Usage: /edit <file> [start[-end]]\n" +
+	"Send the content of file (or the line range), reply with the modified content to edit it"
+
+// A text file read for editing
+type editFile struct {
+	Path    string
+	Lines   []string // without line endings
+	CRLF    bool     // file uses "\r\n" line endings
+	EOL     bool     // file ends with line ending
+	Mode    fs.FileMode
+	Version string // hash of content, to detect concurrent modification
+}
+
+// A confirmed diff waiting for user to save
+type pendingEdit struct {
+	Id      int
+	Path    string
+	Version string
+	Content []byte
+	Mode    fs.FileMode
+}
+
+var (
+	pendingEdits      = map[int]*pendingEdit{}
+	lastPendingEditId int
+	pendingEditsLock  sync.Mutex
+)
+
+func contentVersion(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
+
+func readEditFile(filepath string) (*editFile, error) {
+	stat, err := os.Stat(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("not a regular file")
+	}
+	if stat.Size() > EDIT_MAX_SIZE {
+		return nil, fmt.Errorf("file is too large (%s). Max %s", util.BytesSize(float64(stat.Size())),
+			util.BytesSize(EDIT_MAX_SIZE))
+	}
+	content, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if isBinary(content) {
+		return nil, fmt.Errorf("binary file")
+	}
+	f := &editFile{Path: filepath, Mode: stat.Mode().Perm(), Version: contentVersion(content)}
+	text := string(content)
+	f.CRLF = strings.Contains(text, "\r\n")
+	if f.CRLF {
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	}
+	if text != "" {
+		f.EOL = strings.HasSuffix(text, "\n")
+		f.Lines = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	}
+	return f, nil
+}
+
+// Return the file content with lines [start, end] (1-based, inclusive) replaced by newLines
+func (f *editFile) replace(start int, end int, newLines []string) []byte {
+	lines := append(append(append([]string{}, f.Lines[:start-1]...), newLines...), f.Lines[end:]...)
+	eol := "\n"
+	if f.CRLF {
+		eol = "\r\n"
+	}
+	content := strings.Join(lines, eol)
+	if f.EOL && len(lines) > 0 {
+		content += eol
+	}
+	return []byte(content)
+}
+
+// Parse line range "start[-end]". end is 0 if omitted after "-", meaning the last line
+func parseLineRange(str string) (start int, end int, err error) {
+	startStr, endStr, hasEnd := strings.Cut(str, "-")
+	if start, err = strconv.Atoi(startStr); err != nil || start < 1 {
+		return 0, 0, fmt.Errorf("invalid line range '%s'", str)
+	}
+	if !hasEnd {
+		return start, start, nil
+	}
+	if endStr == "" {
+		return start, 0, nil
+	}
+	if end, err = strconv.Atoi(endStr); err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid line range '%s'", str)
+	}
+	return start, end, nil
+}
+
+// Handle "/edit <file> [range]". Return the ForceReply prompt with the content (HTML).
+// If redactor is not nil, content containing secrets is refused, as the redacted content
+// sent back would overwrite them
+func editPrompt(payload string, redactor *redact.Redactor) (string, error) {
+	args, err := shlex.Split(payload)
+	if err != nil || len(args) == 0 || len(args) > 2 {
+		return "", errors.New(USAGE_EDIT)
+	}
+	f, err := readEditFile(absPath(args[0]))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", args[0], err)
+	}
+	start, end := 1, len(f.Lines)
+	if len(args) == 2 {
+		if start, end, err = parseLineRange(args[1]); err != nil {
+			return "", err
+		}
+		if end == 0 || end > len(f.Lines) {
+			end = len(f.Lines)
+		}
+		if start > len(f.Lines) {
+			return "", fmt.Errorf("%s has only %d lines", f.Path, len(f.Lines))
+		}
+	}
+	if redactor != nil {
+		if secretLines := redactedLines(f.Lines, start, end, redactor); len(secretLines) > 0 {
+			return "", fmt.Errorf("lines %s contain secrets, which are redacted in chat. "+
+				"Edit a line range without them", strings.Join(secretLines, ", "))
+		}
+	}
+	content := strings.Join(f.Lines[start-1:end], "\n")
+	if len(content) > EDIT_MAX_CHARS {
+		return "", fmt.Errorf("content is too long to edit in a message. Specify a smaller line range: /edit %s %d-%d",
+			args[0], start, start+EDIT_MAX_CHARS/200)
+	}
+	if content == "" {
+		content = "(empty)"
+	}
+	return html.EscapeString(fmt.Sprintf("%s%s\nLines %d-%d of %d, version %s\n", PROMPT_EDIT, f.Path,
+		start, end, len(f.Lines), f.Version)) +
+		"Copy the content below, modify it, then reply to this message with it\n" +
+		"<pre>" + html.EscapeString(content) + "</pre>", nil
+}
+
+// Return numbers of lines [start, end] (1-based, inclusive) which are changed by redactor.
+// At most EDIT_SECRET_LINES_SHOWN are returned
+func redactedLines(lines []string, start int, end int, redactor *redact.Redactor) (numbers []string) {
+	from := max(start-1-VIEW_REDACT_CONTEXT, 0)
+	redacted := redactor.RedactLines(lines[from:end])
+	for i := start - 1; i < end && len(numbers) < EDIT_SECRET_LINES_SHOWN; i++ {
+		if redacted[i-from] != lines[i] {
+			numbers = append(numbers, strconv.Itoa(i+1))
+		}
+	}
+	return
+}
+
+// Handle user's reply to the edit prompt: show the diff and ask for confirmation (HTML).
+// redactor is nil if the diff is not redacted
+func handleEditReply(prompt string, input string, redactor *redact.Redactor) (string, *tele.ReplyMarkup, error) {
+	lines := strings.Split(prompt, "\n")
+	path := strings.TrimPrefix(lines[0], PROMPT_EDIT)
+	var start, end, total int
+	var version string
+	if len(lines) < 2 {
+		return "", nil, errors.New(MSG_INVALID)
+	}
+	if n, _ := fmt.Sscanf(lines[1], "Lines %d-%d of %d, version %s", &start, &end, &total, &version); n != 4 {
+		return "", nil, errors.New(MSG_INVALID)
+	}
+	f, err := readEditFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if f.Version != version {
+		return "", nil, fmt.Errorf("%s has been modified since the edit started. To edit it again, use /edit", path)
+	}
+	if start > end { // empty file
+		start, end = 1, 0
+	}
+	newLines := strings.Split(strings.ReplaceAll(input, "\r\n", "\n"), "\n")
+	diff := util.UnifiedDiff(path, path, f.Lines[start-1:end], newLines, start, EDIT_DIFF_CONTEXT)
+	if diff == "" {
+		return "", nil, fmt.Errorf("no changes")
+	}
+	if redactor != nil {
+		diff = redactor.RedactText(diff)
+	}
+	if len(diff) > EDIT_MAX_CHARS {
+		diff = strings.ToValidUTF8(diff[:EDIT_MAX_CHARS], "") + "\n... (diff truncated)"
+	}
+	pendingEditsLock.Lock()
+	lastPendingEditId++
+	edit := &pendingEdit{
+		Id:      lastPendingEditId,
+		Path:    path,
+		Version: version,
+		Content: f.replace(start, end, newLines),
+		Mode:    f.Mode,
+	}
+	pendingEdits[edit.Id] = edit
+	pendingEditsLock.Unlock()
+	data := html.EscapeString(fmt.Sprintf("Diff %d - %s\nReview the changes, then save or cancel\n", edit.Id, path)) +
+		"<pre>" + html.EscapeString(diff) + "</pre>"
+	menu := &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{
+		{{Text: "✓ Save", Data: fmt.Sprintf("save_%d", edit.Id)}, {Text: "Cancel", Data: fmt.Sprintf("cancel_%d", edit.Id)}},
+	}}
+	return data, menu, nil
+}
+
+// Take the pending edit out
+func takePendingEdit(id int) *pendingEdit {
+	pendingEditsLock.Lock()
+	defer pendingEditsLock.Unlock()
+	edit := pendingEdits[id]
+	delete(pendingEdits, id)
+	return edit
+}
+
+// Write the edit atomically, backing up the original file as <file>.bak.
+// Fail if the file has been modified since the edit started
+func (edit *pendingEdit) save() (string, error) {
+	target, err := filepath.EvalSymlinks(edit.Path)
+	if err != nil {
+		return "", err
+	}
+	original, err := os.ReadFile(target)
+	if err != nil {
+		return "", err
+	}
+	if contentVersion(original) != edit.Version {
+		return "", fmt.Errorf("%s has been modified since the edit started. To edit it again, use /edit", edit.Path)
+	}
+	backup := target + EDIT_BACKUP_SUFFIX
+	if err := util.WriteFileAtomic(backup, bytes.NewReader(original), edit.Mode); err != nil {
+		return "", fmt.Errorf("failed to backup: %w", err)
+	}
+	if err := util.WriteFileAtomic(target, bytes.NewReader(edit.Content), edit.Mode); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
@@ -0,0 +1,134 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/sagan/tgshell/config"
+	"github.com/sagan/tgshell/util/redact"
+)
+
+const REDACTED = redact.MASK
+
+var ENV_NAME_REGEXP = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// A env variable set by user in a session
+type sessionEnv struct {
+	Name   string
+	Value  string
+	Secure bool // value is a secret, never displayed
+}
+
+// Set a session env, replacing the existing one of same name
+func (s *TgExecutorSession) SetEnv(name string, value string, secure bool) {
+	s.UnsetEnv(name)
+	s.env = append(s.env, &sessionEnv{Name: name, Value: value, Secure: secure})
+	s.updateSecrets()
+}
+
+// Unset a session env. Return false if it does not exist
+func (s *TgExecutorSession) UnsetEnv(name string) bool {
+	index := slices.IndexFunc(s.env, func(e *sessionEnv) bool { return e.Name == name })
+	if index == -1 {
+		return false
+	}
+	s.env = slices.Delete(s.env, index, index+1)
+	s.updateSecrets()
+	return true
+}
+
+// Update the snapshot of secure env values, which is read by redactors outside of event loop
+func (s *TgExecutorSession) updateSecrets() {
+	var secrets []string
+	for _, e := range s.env {
+		if e.Secure && e.Value != "" {
+			secrets = append(secrets, e.Value)
+		}
+	}
+	s.envSecrets.Store(&secrets)
+}
+
+// Return "KEY=VALUE" entries of session env, which are passed to cmdlines
+func (s *TgExecutorSession) Environ() (env []string) {
+	for _, e := range s.env {
+		env = append(env, e.Name+"="+e.Value)
+	}
+	return
+}
+
+// Return all secret values of session: bot token, executor secrets and secure env values.
+// Safe to call outside of event loop
+func (s *TgExecutorSession) Secrets() (secrets []string) {
+	secrets = append(secrets, config.ConfigData.TelegramToken)
+	if executorConfig := config.GetExecutor(s.Executor.Name()); executorConfig != nil {
+		secrets = append(secrets, executorConfig.Secrets()...)
+	}
+	if envSecrets := s.envSecrets.Load(); envSecrets != nil {
+		secrets = append(secrets, *envSecrets...)
+	}
+	return
+}
+
+// Human readable env listing of session, with secret values redacted
+func (s *TgExecutorSession) envList() string {
+	data := fmt.Sprintf("Env of %s\n%s\n", s.Executor.Name(), ENV_TIP)
+	if executorConfig := config.GetExecutor(s.Executor.Name()); executorConfig != nil {
+		data += "\nConfig:\n"
+		for _, entry := range executorConfig.Env {
+			data += entry + "\n"
+		}
+		for _, entry := range executorConfig.SecretEnv {
+			name, _, _ := strings.Cut(entry, "=")
+			data += name + "=" + REDACTED + "\n"
+		}
+	}
+	data += "\nSession:\n"
+	for _, e := range s.env {
+		if e.Secure {
+			data += e.Name + "=" + REDACTED + "\n"
+		} else {
+			data += e.Name + "=" + e.Value + "\n"
+		}
+	}
+	return data
+}
+
+// Set output redaction of session: 1 on, -1 off, 0 follow executor config
+func (s *TgExecutorSession) SetRedact(mode int32) {
+	s.redactMode.Store(mode)
+}
+
+// Whether output of session should be redacted
+func (s *TgExecutorSession) Redacting() bool {
+	switch s.redactMode.Load() {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+	if executorConfig := config.GetExecutor(s.Executor.Name()); executorConfig != nil {
+		return !executorConfig.NoRedact
+	}
+	return true
+}
+
+// Create a redactor of a output stream of session. It follows later changes of session secrets
+func (s *TgExecutorSession) newRedactor() *redact.Redactor {
+	return redact.New(config.ConfigData.RedactPatterns, s.Secrets)
+}
+
+// Create a redactor if output of session should be redacted, otherwise return nil
+func (s *TgExecutorSession) redactorIfEnabled() *redact.Redactor {
+	if !s.Redacting() {
+		return nil
+	}
+	return s.newRedactor()
+}
+
+// Message replied to /setenv or /unsetenv in a session whose executor is not in oneshot mode
+func sessionEnvUnsupported(s *TgExecutorSession) string {
+	return fmt.Sprintf("Session env only applies to oneshot executors, but '%s' runs in a pty. "+
+		"Set env in the shell instead, e.g.: export KEY=VALUE", s.Executor.Name())
+}
@@ -3,6 +3,7 @@ package telegram
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
@@ -13,12 +14,16 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/google/shlex"
 	tele "gopkg.in/telebot.v3"
 
 	"github.com/sagan/tgshell/config"
 	"github.com/sagan/tgshell/constants"
 	"github.com/sagan/tgshell/executor"
+	"github.com/sagan/tgshell/job"
 	"github.com/sagan/tgshell/util"
+	"github.com/sagan/tgshell/util/archive"
+	"github.com/sagan/tgshell/util/redact"
 	"github.com/sagan/tgshell/version"
 )
 
@@ -43,25 +48,80 @@ const USAGE_ADDEXECUTOR = `Usage: /addexecutor <name> <type> [config]
 E.g.: /addexecutor myssh ssh 1.2.3.4`
 const USAGE_DELEXECUTOR = `Usage: /delexecutor <name>
 E.g.: /delexecutor myssh`
-const USAGE_RUN = `Usage: /run <cmdline>
-E.g.: /run /usr/bin/ls -lh`
+const USAGE_RUN = `Usage: /run [options] <cmdline>
+Options:
+* -t, --timeout <seconds> : Timeout of cmdline in oneshot executor. 0 means no timeout
+* --ttl <seconds> : Delete output messages after ttl
+* --ttl-cmd : Also delete the cmdline message after ttl
+* -c, --collect <glob> : Send back files matching glob which are modified by cmdline.
+  Files written to the dir of $TGSHELL_OUT env are always sent back
+* -- : End of options
+E.g.: /run /usr/bin/ls -lh
+/run -t 300 make all
+/run --collect dist/*.tar.gz make dist`
+const USAGE_BG = `Usage: /bg <cmdline>
+Run cmdline as a background job using system shell, without timeout. To manage jobs, send /jobs
+E.g.: /bg make all`
+const USAGE_PIPE = `Usage: /pipe [options] <cmdline>
+Reply to a document or text message with it. The document content or message text is used as the stdin
+of cmdline, which is run in the default (shell) executor. [options] are the same as /run
+E.g.: /pipe jq .`
+const USAGE_SETENV = `Usage: /setenv [-s] <KEY>=<VALUE>
+Set env of active executor session. With -s, the value is secure: it's treated as secret,
+redacted from env list, logs and output, and the message is deleted
+E.g.: /setenv GOFLAGS=-mod=mod`
+const USAGE_UNSETENV = `Usage: /unsetenv <KEY>`
+const USAGE_REDACT = `Usage: /redact [on|off|default]
+Toggle masking of secrets and credentials (executor secrets, secure env, AWS keys, JWT, private keys, and
+"redactpatterns" in config) in output of active executor session. "default" follows executor config.
+Without argument, display current status`
+const USAGE_WATCH = `Usage: /watch <interval> <cmdline>
+Run cmdline periodically in active executor (if it's in oneshot mode, otherwise the default executor),
+and display the latest output in a single message. <interval> is seconds or a duration like "1m"
+E.g.: /watch 5 df -h`
+const USAGE_ALERT = `Usage: /alert [list|add|del]
+/alert add <regex> : Notify when a output line of active (interactive) executor session matches regex
+/alert add -j <job_id> <regex> : Notify when a output line of background job matches regex
+/alert del <id> : Delete alert
+E.g.: /alert add (?i)error|segmentation fault`
+const USAGE_MKDIR = `Usage: /mkdir <dir>...
+Create dirs (and parents) relative to cwd`
+const USAGE_RM = `Usage: /rm [-r] <path>...
+Delete files or empty dirs. With -r, delete dirs recursively`
+const USAGE_MV = `Usage: /mv <src> <dst>
+Move or rename file or dir. If <dst> is an existing dir, move into it
+Quote paths with spaces, e.g.: /mv "my file.txt" docs/`
 const USAGE_ADDCMD = `Usage: /addcmd <name> <cmdline>
 E.g.: /addcmd ping ping -c 5 8.8.8.8`
 const USAGE_DELCMD = `Usage: /delcmd <name>
 E.g.: /delcmd ping`
-const USAGE_GETFILE = "Usage: /getfile /path/to/file.txt"
+const USAGE_GETFILE = "Usage: /getfile /path/to/file.txt\n" +
+	"Send a file as parts with a manifest of checksums: /getfile --split /path/to/file\n" +
+	"Download a dir as archive: /getfile -r [-f zip|tar.gz] [-i include-glob]... [-x exclude-glob]... /path/to/dir\n" +
+	"Archive larger than upload limit is split into parts"
 const USAGE_CD = `Usage: /cd [dir]
 [dir] default to user home dir`
 const USAGE_RAW = `Usage: /raw <sequence>
 <sequence> is a C-style escape string. E.g.:
 \x03pwd\n : Send 0x03 (Ctrl-C) + "pwd" + "\n"`
+const USAGE_RECORD = `Usage: /record [on|off]
+Record input and output of active executor session to an asciicast v2 (.cast) file.
+Without argument, display recording status. To get recordings, send /recordings`
+const USAGE_RESIZE = `Usage: /resize [<cols>x<rows>]
+E.g.: /resize 60x40
+Without argument, display current size. Default size can be set in config`
 
 var CTRL_SEQUENCE_REGEXP = regexp.MustCompile(`^(?i)(Ctrl[-\+]|\^)(?P<char>\S)$`)
+var PTY_SIZE_REGEXP = regexp.MustCompile(`^(?P<cols>\d+)\s*[xX*]\s*(?P<rows>\d+)$`)
 
 func event_loop(ctx context.Context, bot *tele.Bot, servicesProxy *ServicesProxy,
 	activeSessions TgActiveSessions, executorSessions map[string]*TgExecutorSession,
-	commander chan *TgCommad, messenger chan *TgGlobalMsg) {
+	commander chan *TgCommad, messenger chan *TgGlobalMsg, deleter *messageDeleter) {
 	globalCancelSign := make(chan struct{})
+	// redactor of local file content shown in chat, following the default executor session. nil if not redacting
+	fileRedactor := func() *redact.Redactor {
+		return executorSessions[config.DEFAULT_EXECUTOR].redactorIfEnabled()
+	}
 main:
 	for {
 		select {
@@ -104,70 +164,11 @@ main:
 				}
 			case "/files":
 				{
-					prefix := tgcmdPayload
 					if cwd, err := os.Getwd(); err != nil {
 						tgcmd.Output <- MSG_INVALID
-					} else if files, err := os.ReadDir(cwd); err != nil {
+					} else if data, menu, err := newFilesView(cwd, tgcmdPayload).render(); err != nil {
 						tgcmd.Output <- MSG_INVALID
 					} else {
-						no := 0
-						data := fmt.Sprintf("Files - %s\nPrefix: %s\n%s\n\n", cwd, prefix, FILES_TIP)
-						chars := utf8.RuneCountInString(data)
-						var inlineKeyboard [][]tele.InlineButton
-						var inlineKeyboardRow []tele.InlineButton
-						for _, file := range files {
-							if prefix != "" && !strings.HasPrefix(file.Name(), prefix) {
-								if file.Name() < prefix {
-									continue
-								} else {
-									break
-								}
-							}
-							flag := "-"
-							size := "?"
-							if file.IsDir() {
-								flag = "d"
-								size = "-"
-							} else if stat, err := os.Stat(path.Join(cwd, file.Name())); err == nil {
-								size = util.BytesSizeAround(float64(stat.Size()))
-							}
-							filedata := fmt.Sprintf("%-2d %1s %4s  %s\n", no, flag, size, file.Name())
-							if newchars := utf8.RuneCountInString(filedata) + chars; newchars > constants.TG_TEXT_LIMIT {
-								break
-							} else {
-								data += filedata
-								chars = newchars
-							}
-							if file.IsDir() {
-								inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
-									Text: fmt.Sprintf("cd %d", no),
-									Data: fmt.Sprintf("cd_%d", no),
-								})
-							} else {
-								inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
-									Text: fmt.Sprintf("↓ %d", no),
-									Data: fmt.Sprintf("get_%d", no),
-								})
-							}
-							if len(inlineKeyboardRow) >= constants.TG_ROW_BUTTONS {
-								inlineKeyboard = append(inlineKeyboard, inlineKeyboardRow)
-								inlineKeyboardRow = nil
-							}
-							no++
-							if no > constants.TG_FILES_MAX {
-								break
-							}
-						}
-						inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
-							Text: "cd .",
-							Data: "cd_.",
-						})
-						inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
-							Text: "cd ..",
-							Data: "cd_..",
-						})
-						inlineKeyboard = append(inlineKeyboard, inlineKeyboardRow)
-						menu := &tele.ReplyMarkup{InlineKeyboard: inlineKeyboard}
 						tgcmd.C.Reply(data, menu, tele.NoPreview)
 					}
 					close(tgcmd.Output)
@@ -294,7 +295,7 @@ main:
 							doNotCloseOutput = true
 							result = fmt.Sprintf("Run %s: %s", index, cmdline)
 							tgcmd.Output <- cmdline
-							command_run(tgcmd.ctx, session, tgcmd.Output, cmdline)
+							command_run_with_options(tgcmd.ctx, tgcmd.C, session, tgcmd.Output, deleter, &runOptions{}, cmdline)
 						} else if action == "add" {
 							result = fmt.Sprintf("Add %s: %s", index, cmdline)
 							config.AddExecutorButton(session.Executor.Name(), cmdline)
@@ -322,33 +323,290 @@ main:
 								setCommands(bot, tgcmd.Chatid)
 							}
 						}
-					} else if strings.HasPrefix(msg.Text, "Files ") {
-						lines := strings.Split(msg.Text, "\n")
-						// first line: "Files - <filename>"
-						dir := lines[0][8:]
-						log.Printf("dir=%s, action=%s, index=%s", dir, action, index)
-						if index == "." || index == ".." {
-							if action == "cd" {
-								filepath := path.Clean(path.Join(dir, index))
-								tgcmd.Output <- fmt.Sprintf("cd %s", filepath)
-								os.Chdir(filepath)
+					} else if strings.HasPrefix(msg.Text, "Jobs ") {
+						id, _ := strconv.Atoi(index)
+						if j := job.Get(id); j == nil {
+							result = fmt.Sprintf("Job %s not found", index)
+						} else if action == "tail" {
+							if data, err := j.Tail(JOB_TAIL_SIZE); err != nil {
+								result = fmt.Sprintf("Failed to read output: %v", err)
+							} else if data == "" {
+								tgcmd.Output <- fmt.Sprintf("Job %d: no output", j.Id)
+							} else {
+								tgcmd.Output <- redactJobOutput(executorSessions[config.DEFAULT_EXECUTOR], data)
+							}
+						} else if action == "attach" {
+							if err := attachJob(j, executorSessions[config.DEFAULT_EXECUTOR], tgcmd.Chatid,
+								messenger); err != nil {
+								result = err.Error()
+							} else {
+								result = fmt.Sprintf("Attached to job %d", j.Id)
+								tgcmd.Output <- fmt.Sprintf("Attached to job %d '%s'. To detach, send /jobs", j.Id, j.Cmdline)
+							}
+						} else if action == "detach" {
+							if err := j.Detach(tgcmd.Chatid); err != nil {
+								result = err.Error()
+							} else {
+								result = fmt.Sprintf("Detached from job %d", j.Id)
+							}
+						} else if action == "kill" {
+							if err := j.Kill(); err != nil {
+								result = err.Error()
+							} else {
+								result = fmt.Sprintf("Killing job %d", j.Id)
+							}
+						} else if action == "get" {
+							go func(ctx context.Context, cancelSign <-chan struct{}, C tele.Context,
+								session *TgExecutorSession) {
+								ctx, cancel := util.ContextWithCancelSign(ctx, cancelSign)
+								defer cancel()
+								sendJobOutput(ctx, bot, C, session, j)
+							}(ctx, globalCancelSign, tgcmd.C, executorSessions[config.DEFAULT_EXECUTOR])
+						} else {
+							result = MSG_INVALID
+						}
+						if action == "attach" || action == "detach" {
+							data, menu := jobsMessage(tgcmd.Chatid)
+							bot.Edit(msg, data, menu, tele.NoPreview)
+						}
+					} else if strings.HasPrefix(msg.Text, "File - ") {
+						firstLine, _, _ := strings.Cut(msg.Text, "\n")
+						filepath := strings.TrimPrefix(firstLine, "File - ")
+						switch action {
+						case "get":
+							go func(ctx context.Context, cancelSign <-chan struct{}, C tele.Context) {
+								ctx, cancel := util.ContextWithCancelSign(ctx, cancelSign)
+								defer cancel()
+								sendFileWithProgress(ctx, bot, C, filepath)
+							}(ctx, globalCancelSign, tgcmd.C)
+						case "archive":
+							go func(ctx context.Context, cancelSign <-chan struct{}, C tele.Context) {
+								ctx, cancel := util.ContextWithCancelSign(ctx, cancelSign)
+								defer cancel()
+								sendArchive(ctx, C, filepath, &archive.Options{Format: archive.FORMAT_ZIP})
+							}(ctx, globalCancelSign, tgcmd.C)
+						case "cd":
+							if err := os.Chdir(filepath); err != nil {
+								result = fmt.Sprintf("Failed to cd %s: %v", filepath, err)
+							} else {
+								result = fmt.Sprintf("cd %s", filepath)
+							}
+						case "stat":
+							data, menu := fileMenu(filepath)
+							bot.Edit(msg, data, menu, tele.NoPreview)
+						case "rm":
+							if index == "ask" {
+								bot.Edit(msg, msg.Text, fileDeleteConfirmMenu(filepath), tele.NoPreview)
+							} else if index == "yes" || index == "recursive" {
+								// a dir is only deleted recursively after the confirmation showing it's entries count
+								var err error
+								if index == "recursive" {
+									err = os.RemoveAll(filepath)
+								} else {
+									err = os.Remove(filepath)
+								}
+								if err != nil {
+									result = fmt.Sprintf("Failed to delete: %v", err)
+								} else {
+									result = fmt.Sprintf("Deleted %s", filepath)
+									bot.Edit(msg, fmt.Sprintf("File - %s\nDeleted", filepath), &tele.ReplyMarkup{})
+								}
 							} else {
+								data, menu := fileMenu(filepath)
+								bot.Edit(msg, data, menu, tele.NoPreview)
+							}
+						case "view":
+							view := &fileView{Path: filepath}
+							if data, menu, err := view.open(1, fileRedactor()); err != nil {
+								result = fmt.Sprintf("Failed to view: %v", err)
+							} else {
+								bot.Send(msg.Chat, data, menu, tele.ModeHTML)
+							}
+						case "rename", "move", "copy", "chmod":
+							bot.Send(msg.Chat, filePrompt(action, filepath), &tele.ReplyMarkup{ForceReply: true}, tele.NoPreview)
+						default:
+							result = MSG_INVALID
+						}
+					} else if view := parseFileView(msg.Text); view != nil {
+						if lines, err := readTextLines(view.Path); err != nil {
+							result = fmt.Sprintf("Failed to read %s: %v", view.Path, err)
+						} else {
+							refresh := true
+							switch action {
+							case "top", "prev", "next", "end":
+								view.move(lines, action)
+							case "findnext", "findprev":
+								from := max(view.Highlight, view.Line-1) + 1
+								if action == "findprev" {
+									from = view.Line - 1
+									if view.Highlight > 0 {
+										from = view.Highlight - 1
+									}
+								}
+								if !view.find(lines, from, action == "findprev") {
+									refresh = false
+									result = "No more matches"
+								}
+							case "search":
+								refresh = false
+								bot.Send(msg.Chat, viewSearchPrompt(view), &tele.ReplyMarkup{ForceReply: true}, tele.NoPreview)
+							default:
+								refresh = false
 								result = MSG_INVALID
 							}
-						} else if fileinfo := util.FindLineDataByFirstField(lines, index); fileinfo == "" || len(fileinfo) < 9 {
-							// "%1s %4s  %s\n", 8 bytes before filename
+							if refresh {
+								data, menu := view.render(lines, fileRedactor())
+								bot.Edit(msg, data, menu, tele.ModeHTML)
+							}
+						}
+					} else if strings.HasPrefix(msg.Text, "Diff ") {
+						id, _ := strconv.Atoi(index)
+						if edit := takePendingEdit(id); edit == nil {
+							result = fmt.Sprintf("Edit %s not found", index)
+						} else if action == "save" {
+							if backup, err := edit.save(); err != nil {
+								result = fmt.Sprintf("Failed to save: %v", err)
+								tgcmd.C.Reply(result)
+							} else {
+								result = fmt.Sprintf("Saved %s", edit.Path)
+								tgcmd.C.Reply(fmt.Sprintf("Saved %s. Original backed up as %s", edit.Path, backup))
+							}
+						} else {
+							result = "Edit cancelled"
+						}
+						bot.EditReplyMarkup(msg, &tele.ReplyMarkup{})
+					} else if strings.HasPrefix(msg.Text, UPLOAD_PROMPT) {
+						firstLine, _, _ := strings.Cut(msg.Text, "\n")
+						dst := strings.TrimPrefix(firstLine, UPLOAD_PROMPT)
+						if msg.ReplyTo == nil || msg.ReplyTo.Document == nil {
+							result = "The original file message is not found"
+						} else if action == "overwrite" || action == "keep" {
+							if action == "keep" {
+								dst = uniquePath(dst)
+							}
+							bot.Edit(msg, firstLine+"\nSaving to "+dst, &tele.ReplyMarkup{})
+							go func(ctx context.Context, cancelSign <-chan struct{}, chatid int64, tgdocument *tele.Document) {
+								ctx, cancel := util.ContextWithCancelSign(ctx, cancelSign)
+								defer cancel()
+								saveDocument(ctx, bot, messenger, chatid, tgdocument, dst, false)
+							}(ctx, globalCancelSign, msg.Chat.ID, msg.ReplyTo.Document)
+						} else if action == "cancel" {
+							bot.Edit(msg, firstLine+"\nCancelled", &tele.ReplyMarkup{})
+						} else {
 							result = MSG_INVALID
-						} else if filepath := path.Clean(path.Join(dir, fileinfo[8:])); filepath == "" {
+						}
+					} else if strings.HasPrefix(msg.Text, "Transfer ") {
+						id, _ := strconv.Atoi(index)
+						if t := getTransfer(id); t == nil {
+							result = fmt.Sprintf("Transfer %s not found", index)
+						} else if action == "cancel" {
+							t.Cancel()
+							result = fmt.Sprintf("Transfer %d cancelled", t.Id)
+						} else {
 							result = MSG_INVALID
-						} else if action == "cd" {
-							tgcmd.Output <- fmt.Sprintf("cd %s", filepath)
-							os.Chdir(filepath)
-						} else if action == "get" {
+						}
+					} else if strings.HasPrefix(msg.Text, "Tail ") {
+						id, _ := strconv.Atoi(index)
+						if t := getTail(id); t == nil {
+							result = fmt.Sprintf("Tail %s not found", index)
+						} else if action == "stop" {
+							t.Stop()
+							result = fmt.Sprintf("Tail %d stopped", t.Id)
+						} else {
+							result = MSG_INVALID
+						}
+					} else if strings.HasPrefix(msg.Text, "Watch ") {
+						id, _ := strconv.Atoi(index)
+						if w := getWatch(id); w == nil {
+							result = fmt.Sprintf("Watch %s not found", index)
+						} else if action == "stop" {
+							w.Stop()
+							result = fmt.Sprintf("Watch %d stopped", w.Id)
+						} else if action == "pause" || action == "resume" {
+							w.SetPaused(action == "pause")
+							result = fmt.Sprintf("Watch %d %sd", w.Id, action)
+						} else {
+							result = MSG_INVALID
+						}
+					} else if strings.HasPrefix(msg.Text, "Recordings ") {
+						lines := strings.Split(msg.Text, "\n")
+						if recording := util.FindLineDataByFirstField(lines, index); recording == "" || action != "get" {
+							result = MSG_INVALID
+						} else {
+							filename, _ := util.SplitFirstAndOthers(recording)
+							filepath := path.Join(recordingsDir(), path.Base(filename))
 							go func(C tele.Context) {
 								C.Reply(fmt.Sprintf("Sending %s", filepath))
-								C.Reply(&tele.Document{File: tele.FromDisk(filepath), FileName: path.Base(filepath)})
+								C.Reply(&tele.Document{File: localFile(filepath), FileName: path.Base(filepath)})
 							}(tgcmd.C)
 						}
+					} else if strings.HasPrefix(msg.Text, "Files ") {
+						lines := strings.Split(msg.Text, "\n")
+						view := parseFilesView(msg.Text)
+						log.Printf("dir=%s, action=%s, index=%s", view.Dir, action, index)
+						refresh := true
+						switch action {
+						case "page":
+							if index == "next" {
+								view.Page++
+							} else {
+								view.Page--
+							}
+						case "sort":
+							view.Sort, view.Page = index, 0
+						case "hidden":
+							view.ShowHidden, view.Page = !view.ShowHidden, 0
+						case "cd":
+							filepath, err := "", error(nil)
+							if index == "." || index == ".." {
+								filepath = path.Clean(path.Join(view.Dir, index))
+							} else {
+								filepath, err = view.entryPath(lines, index)
+							}
+							if err != nil {
+								result = err.Error()
+								refresh = false
+							} else if err := os.Chdir(filepath); err != nil {
+								result = fmt.Sprintf("Failed to cd %s: %v", filepath, err)
+							} else {
+								result = fmt.Sprintf("cd %s", filepath)
+								if filepath != view.Dir {
+									view = newFilesView(filepath, "")
+								}
+							}
+						case "menu":
+							refresh = false
+							filepath, err := view.Dir, error(nil)
+							if index != "." {
+								filepath, err = view.entryPath(lines, index)
+							}
+							if err != nil {
+								result = err.Error()
+							} else {
+								data, menu := fileMenu(filepath)
+								bot.Reply(msg, data, menu, tele.NoPreview)
+							}
+						case "get":
+							refresh = false
+							if filepath, err := view.entryPath(lines, index); err != nil {
+								result = err.Error()
+							} else {
+								go func(C tele.Context) {
+									C.Reply(fmt.Sprintf("Sending %s", filepath))
+									C.Reply(&tele.Document{File: localFile(filepath), FileName: path.Base(filepath)})
+								}(tgcmd.C)
+							}
+						default:
+							refresh = false
+							result = MSG_INVALID
+						}
+						if refresh {
+							if data, menu, err := view.render(); err != nil {
+								result = fmt.Sprintf("Failed to read %s: %v", view.Dir, err)
+							} else {
+								bot.Edit(msg, data, menu, tele.NoPreview)
+							}
+						}
 					} else {
 						result = MSG_INVALID
 					}
@@ -357,28 +615,71 @@ main:
 						close(tgcmd.Output)
 					}
 				}
+			case "/view":
+				{
+					if view, err := parseViewPayload(tgcmdPayload); err != nil {
+						tgcmd.Output <- USAGE_VIEW
+					} else if data, menu, err := view.open(view.Line, fileRedactor()); err != nil {
+						tgcmd.Output <- fmt.Sprintf("Failed to view %s: %v", view.Path, err)
+					} else {
+						tgcmd.C.Reply(data, menu, tele.ModeHTML)
+					}
+					close(tgcmd.Output)
+				}
+			case "/edit":
+				{
+					if data, err := editPrompt(tgcmdPayload, fileRedactor()); err != nil {
+						tgcmd.Output <- err.Error()
+					} else {
+						tgcmd.C.Reply(data, &tele.ReplyMarkup{ForceReply: true}, tele.ModeHTML)
+					}
+					close(tgcmd.Output)
+				}
 			case "/getfile":
 				{
-					if tgcmdPayload == "" {
+					options, err := parseGetfileOptions(tgcmdPayload)
+					if tgcmdPayload == "" || err != nil {
+						if err != nil {
+							tgcmd.Output <- fmt.Sprintf("Invalid args: %v. ", err)
+						}
 						tgcmd.Output <- USAGE_GETFILE
 					} else {
 						filepath := ""
-						if path.IsAbs(tgcmdPayload) {
-							filepath = tgcmdPayload
+						if path.IsAbs(options.Path) {
+							filepath = path.Clean(options.Path)
 						} else if cwd, err := os.Getwd(); err == nil {
-							filepath = path.Clean(path.Join(cwd, tgcmdPayload))
+							filepath = path.Clean(path.Join(cwd, options.Path))
 						}
 						if filepath == "" {
 							tgcmd.Output <- MSG_INVALID
 						} else if stat, err := os.Stat(filepath); err != nil {
 							tgcmd.Output <- fmt.Sprintf("File '%s' does NOT exist", filepath)
+						} else if stat.IsDir() && options.Recursive {
+							go func(ctx context.Context, cancelSign <-chan struct{}, C tele.Context) {
+								ctx, cancel := util.ContextWithCancelSign(ctx, cancelSign)
+								defer cancel()
+								sendArchive(ctx, C, filepath, &options.Options)
+							}(ctx, globalCancelSign, tgcmd.C)
+						} else if stat.IsDir() {
+							tgcmd.Output <- fmt.Sprintf("'%s' is a dir. To download it as an archive, use /getfile -r", filepath)
 						} else if !stat.Mode().IsRegular() {
 							tgcmd.Output <- fmt.Sprintf("File '%s' is not a regular file", filepath)
+						} else if options.Split {
+							go func(ctx context.Context, cancelSign <-chan struct{}, C tele.Context) {
+								ctx, cancel := util.ContextWithCancelSign(ctx, cancelSign)
+								defer cancel()
+								sendSplitFile(ctx, bot, C, filepath)
+							}(ctx, globalCancelSign, tgcmd.C)
+						} else if limit := config.ConfigData.UploadLimit(); stat.Size() > limit {
+							tgcmd.Output <- fmt.Sprintf("File '%s' (%s) exceeds the Bot API upload limit (%s). "+
+								"To send it in parts, use /getfile --split", filepath,
+								util.BytesSize(float64(stat.Size())), util.BytesSize(float64(limit)))
 						} else {
-							go func(C tele.Context) {
-								C.Reply(fmt.Sprintf("Sending %s (%s)", filepath, util.BytesSize(float64(stat.Size()))))
-								C.Reply(&tele.Document{File: tele.FromDisk(filepath), FileName: path.Base(filepath)})
-							}(tgcmd.C)
+							go func(ctx context.Context, cancelSign <-chan struct{}, C tele.Context) {
+								ctx, cancel := util.ContextWithCancelSign(ctx, cancelSign)
+								defer cancel()
+								sendFileWithProgress(ctx, bot, C, filepath)
+							}(ctx, globalCancelSign, tgcmd.C)
 						}
 					}
 					close(tgcmd.Output)
@@ -387,46 +688,30 @@ main:
 			case "document":
 				{
 					close(tgcmd.Output)
-					savePath, err := os.Getwd()
+					tgdocument := tgcmd.C.Message().Document
+					options, err := parseUploadCaption(tgcmd.C.Message().Caption)
 					if err != nil {
-						savePath = "."
+						tgcmd.C.Reply(fmt.Sprintf("Invalid caption: %v\n%s", err, USAGE_UPLOAD))
+						break
 					}
-					if userpath := strings.TrimSpace(tgcmd.C.Message().Caption); userpath != "" {
-						if path.IsAbs(userpath) {
-							savePath = userpath
-						} else {
-							savePath = path.Clean(path.Join(savePath, userpath))
+					if err := checkUploadDir(options.Dir); err != nil {
+						tgcmd.C.Reply(fmt.Sprintf("Refused to save file: %v", err))
+						break
+					}
+					dst := options.Dir
+					if !options.Extract {
+						dst = path.Join(options.Dir, path.Base(tgdocument.FileName))
+						if stat, err := os.Lstat(dst); err == nil {
+							data, menu := uploadExistsPrompt(dst, stat)
+							tgcmd.C.Reply(data, menu, tele.NoPreview)
+							break
 						}
 					}
-					go func(ctx context.Context, cancelSign <-chan struct{}, tgtoken string,
-						chatid int64, savepath string, tgdocument *tele.Document) {
+					go func(ctx context.Context, cancelSign <-chan struct{}, chatid int64, dst string, extract bool) {
 						ctx, cancel := util.ContextWithCancelSign(ctx, cancelSign)
 						defer cancel()
-						filename := tgdocument.FileName
-						filepath := path.Join(savepath, filename)
-						messenger <- &TgGlobalMsg{
-							Type:   TYPE_GLOBAL,
-							Chatid: chatid,
-							Data: fmt.Sprintf("Saving file '%s' (%s) to '%s' . To cancel, send /cancel", filename,
-								util.BytesSize(float64(tgdocument.FileSize)), savePath),
-						}
-						err := util.DownloadTgFileToLocal(ctx, tgtoken, tgdocument.FileID, filepath)
-						if err != nil {
-							messenger <- &TgGlobalMsg{
-								Type:   TYPE_GLOBAL,
-								Chatid: chatid,
-								Data:   fmt.Sprintf("Failed to save file to '%s': %v", filepath, err),
-							}
-						} else {
-							messenger <- &TgGlobalMsg{
-								Type:   TYPE_GLOBAL,
-								Chatid: chatid,
-								Data:   "Successfully saved file to the below path:",
-							}
-							messenger <- &TgGlobalMsg{Type: TYPE_GLOBAL, Chatid: chatid, Data: filepath}
-						}
-					}(ctx, globalCancelSign, config.ConfigData.TelegramToken,
-						tgcmd.Chatid, savePath, tgcmd.C.Message().Document)
+						saveDocument(ctx, bot, messenger, chatid, tgdocument, dst, extract)
+					}(ctx, globalCancelSign, tgcmd.Chatid, dst, options.Extract)
 				}
 			case "/help":
 				{
@@ -481,7 +766,7 @@ main:
 					} else if executorSessions[sessionName] == nil {
 						tgcmd.Output <- fmt.Sprintf(MSG_EXECUTOR_NOT_FOUND_TPL, sessionName)
 					} else {
-						executorSessions[sessionName].Executor.Close()
+						executorSessions[sessionName].Close()
 						delete(executorSessions, sessionName)
 						if activeSessions.IsActiveSession(tgcmd.Chatid, sessionName) {
 							delete(activeSessions, tgcmd.Chatid)
@@ -496,7 +781,7 @@ main:
 						if name == config.DEFAULT_EXECUTOR {
 							continue
 						}
-						executorSessions[name].Executor.Close()
+						executorSessions[name].Close()
 						delete(executorSessions, name)
 					}
 					for chatid := range activeSessions {
@@ -557,7 +842,7 @@ main:
 						tgcmd.Output <- fmt.Sprintf("Successfully deleted executor %s", name)
 						for sessionName := range executorSessions {
 							if sessionName == name || strings.HasPrefix(sessionName, name+"_") {
-								executorSessions[sessionName].Executor.Close()
+								executorSessions[sessionName].Close()
 								delete(executorSessions, sessionName)
 							}
 						}
@@ -594,6 +879,71 @@ main:
 					}
 					close(tgcmd.Output)
 				}
+			case "/redact":
+				{
+					session := executorSessions[activeSessions.GetActiveSessionName(tgcmd.Chatid)]
+					mode, ok := map[string]int32{"on": 1, "off": -1, "default": 0}[tgcmdPayload]
+					if ok || tgcmdPayload == "" {
+						if ok {
+							session.SetRedact(mode)
+						}
+						tgcmd.Output <- fmt.Sprintf("Output redaction of %s: %t", session.Executor.Name(), session.Redacting())
+					} else {
+						tgcmd.Output <- USAGE_REDACT
+					}
+					close(tgcmd.Output)
+				}
+			case "/env":
+				{
+					session := executorSessions[activeSessions.GetActiveSessionName(tgcmd.Chatid)]
+					tgcmd.Output <- session.envList()
+					close(tgcmd.Output)
+				}
+			case "/setenv":
+				{
+					session := executorSessions[activeSessions.GetActiveSessionName(tgcmd.Chatid)]
+					secure := false
+					entry := tgcmdPayload
+					if flag, others := util.SplitFirstAndOthers(entry); flag == "-s" {
+						secure, entry = true, others
+					}
+					name, value, ok := strings.Cut(entry, "=")
+					if !executor.IsOneshot(session.Executor) {
+						if secure {
+							bot.Delete(tgcmd.C.Message())
+						}
+						tgcmd.Output <- sessionEnvUnsupported(session)
+					} else if !ok || !ENV_NAME_REGEXP.MatchString(name) {
+						tgcmd.Output <- USAGE_SETENV
+					} else {
+						session.SetEnv(name, value, secure)
+						if secure {
+							bot.Delete(tgcmd.C.Message())
+							messenger <- &TgGlobalMsg{
+								Type:   TYPE_GLOBAL,
+								Chatid: tgcmd.Chatid,
+								Data:   fmt.Sprintf("Successfully set secure env %s of %s", name, session.Executor.Name()),
+							}
+						} else {
+							tgcmd.Output <- fmt.Sprintf("%s=%s", name, value)
+						}
+					}
+					close(tgcmd.Output)
+				}
+			case "/unsetenv":
+				{
+					session := executorSessions[activeSessions.GetActiveSessionName(tgcmd.Chatid)]
+					if !executor.IsOneshot(session.Executor) {
+						tgcmd.Output <- sessionEnvUnsupported(session)
+					} else if tgcmdPayload == "" {
+						tgcmd.Output <- USAGE_UNSETENV
+					} else if !session.UnsetEnv(tgcmdPayload) {
+						tgcmd.Output <- fmt.Sprintf("Env %s is not set in session", tgcmdPayload)
+					} else {
+						tgcmd.Output <- MSG_SUCCESS
+					}
+					close(tgcmd.Output)
+				}
 			case "/addbtn":
 				{
 					executorName := executorSessions[activeSessions.GetActiveSessionName(tgcmd.Chatid)].Executor.Name()
@@ -675,20 +1025,28 @@ main:
 								executorSession = &TgExecutorSession{
 									Executor: newExecutor,
 									Chatid:   tgcmd.Chatid,
+									Name:     newSessionName,
 									// Ready: false, // not ready yet
 								}
 								executorSessions[newSessionName] = executorSession
+								if executorConfig.Record {
+									if _, err := executorSession.StartRecording(); err != nil {
+										tgcmd.Output <- fmt.Sprintf("Failed to start recording: %v", err)
+									}
+								}
 								if newExecutor.Chan() != nil {
-									go func(newExecutor executor.Executor, chatid int64) {
-										for {
-											if data, ok := <-newExecutor.Chan(); !ok {
-												messenger <- &TgGlobalMsg{Type: TYPE_CLOSE, Executor: newExecutor.Name(), Chatid: chatid}
-												break
-											} else {
-												messenger <- &TgGlobalMsg{Executor: newExecutor.Name(), Data: data, Chatid: chatid}
-											}
+									go func(executorSession *TgExecutorSession, chatid int64) {
+										newExecutor := executorSession.Executor
+										redactor := executorSession.newRedactor()
+										scanner := &lineScanner{}
+										for data := range redactor.Stream(newExecutor.Chan(), executorSession.Redacting) {
+											executorSession.recordOutput(data)
+											checkAlerts(executorSession.Name, 0, scanner.Lines(data), messenger)
+											messenger <- &TgGlobalMsg{Executor: newExecutor.Name(), Data: data, Chatid: chatid}
 										}
-									}(newExecutor, tgcmd.Chatid)
+										executorSession.StopRecording()
+										messenger <- &TgGlobalMsg{Type: TYPE_CLOSE, Executor: newExecutor.Name(), Chatid: chatid}
+									}(executorSession, tgcmd.Chatid)
 								}
 								go func(executorSession *TgExecutorSession) {
 									if err := executorSession.Executor.Open(); err != nil {
@@ -763,14 +1121,23 @@ main:
 					tgcmd.Output <- MSG_RESETSECRET
 					close(tgcmd.Output)
 				}
-			case "/run":
+			case "/run", "cmdline":
 				{
-					if tgcmdPayload == "" {
+					options, cmdline := &runOptions{}, tgcmdPayload
+					if tgcmdName == "/run" {
+						var err error
+						if options, cmdline, err = parseRunOptions(tgcmdPayload); err != nil {
+							tgcmd.Output <- fmt.Sprintf("%v\n%s", err, USAGE_RUN)
+							close(tgcmd.Output)
+							break
+						}
+					}
+					if cmdline == "" {
 						tgcmd.Output <- USAGE_RUN
 						close(tgcmd.Output)
 					} else {
-						sessionName := activeSessions.GetActiveSessionName(tgcmd.Chatid)
-						command_run(tgcmd.ctx, executorSessions[sessionName], tgcmd.Output, tgcmdPayload)
+						session := executorSessions[activeSessions.GetActiveSessionName(tgcmd.Chatid)]
+						command_run_with_options(tgcmd.ctx, tgcmd.C, session, tgcmd.Output, deleter, options, cmdline)
 					}
 				}
 			case "/cd":
@@ -789,6 +1156,308 @@ main:
 					}
 					close(tgcmd.Output)
 				}
+			case "/record":
+				{
+					session := executorSessions[activeSessions.GetActiveSessionName(tgcmd.Chatid)]
+					switch tgcmdPayload {
+					case "":
+						if recording := session.Recording(); recording != "" {
+							tgcmd.Output <- fmt.Sprintf("Recording %s to %s", session.Executor.Name(), recording)
+						} else {
+							tgcmd.Output <- fmt.Sprintf("Not recording %s\n%s", session.Executor.Name(), USAGE_RECORD)
+						}
+					case "on":
+						if recording, err := session.StartRecording(); err != nil {
+							tgcmd.Output <- fmt.Sprintf("Failed to start recording: %v", err)
+						} else {
+							tgcmd.Output <- fmt.Sprintf("Recording %s to %s", session.Executor.Name(), recording)
+						}
+					case "off":
+						if recording := session.StopRecording(); recording != "" {
+							tgcmd.Output <- fmt.Sprintf("Recording stopped. Saved to %s", recording)
+						} else {
+							tgcmd.Output <- "Not recording"
+						}
+					default:
+						tgcmd.Output <- USAGE_RECORD
+					}
+					close(tgcmd.Output)
+				}
+			case "/recordings":
+				{
+					close(tgcmd.Output)
+					recordings, err := listRecordings()
+					if err != nil {
+						tgcmd.C.Reply(fmt.Sprintf("Failed to list recordings: %v", err))
+						break
+					}
+					if len(recordings) > constants.TG_FILES_MAX {
+						recordings = recordings[:constants.TG_FILES_MAX]
+					}
+					data := fmt.Sprintf("Recordings (%d)\n%s\n\n", len(recordings), RECORDINGS_TIP)
+					chars := utf8.RuneCountInString(data)
+					var inlineKeyboard [][]tele.InlineButton
+					var inlineKeyboardRow []tele.InlineButton
+					for i, recording := range recordings {
+						recordingdata := fmt.Sprintf("%d  %s  %s\n", i, recording.Name(),
+							util.BytesSizeAround(float64(recording.Size())))
+						if newchars := utf8.RuneCountInString(recordingdata) + chars; newchars > constants.TG_TEXT_LIMIT {
+							break
+						} else {
+							data += recordingdata
+							chars = newchars
+						}
+						inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
+							Text: fmt.Sprintf("↓ %d", i),
+							Data: fmt.Sprintf("get_%d", i),
+						})
+						if len(inlineKeyboardRow) >= constants.TG_ROW_BUTTONS {
+							inlineKeyboard = append(inlineKeyboard, inlineKeyboardRow)
+							inlineKeyboardRow = nil
+						}
+					}
+					if len(inlineKeyboardRow) > 0 {
+						inlineKeyboard = append(inlineKeyboard, inlineKeyboardRow)
+						inlineKeyboardRow = nil
+					}
+					menu := &tele.ReplyMarkup{InlineKeyboard: inlineKeyboard}
+					tgcmd.C.Reply(data, menu, tele.NoPreview)
+				}
+			case "/bg":
+				{
+					session := executorSessions[config.DEFAULT_EXECUTOR]
+					commander, ok := session.Executor.(executor.Commander)
+					if tgcmdPayload == "" {
+						tgcmd.Output <- USAGE_BG
+					} else if !ok {
+						tgcmd.Output <- "The default executor does not support background jobs"
+					} else if cmd, err := commander.Command(tgcmdPayload, session.Environ()); err != nil {
+						tgcmd.Output <- fmt.Sprintf("Failed to create job: %v", err)
+					} else if j, err := job.Start(cmd, tgcmdPayload, tgcmd.Chatid, func(j *job.Job) {
+						clearAlerts("", j.Id)
+						messenger <- &TgGlobalMsg{Type: TYPE_GLOBAL, Chatid: j.Chatid, Data: redactJobOutput(session,
+							fmt.Sprintf("Job %d '%s' finished\n%s", j.Id, j.Cmdline, j.Result()))}
+					}); err != nil {
+						tgcmd.Output <- fmt.Sprintf("Failed to start job: %v", err)
+					} else {
+						listenJobAlerts(j, session, messenger)
+						tgcmd.Output <- fmt.Sprintf("Job %d started (pid %d). To manage, send /jobs", j.Id, j.Pid)
+					}
+					close(tgcmd.Output)
+				}
+			case "/pipe":
+				{
+					options, cmdline, err := parseRunOptions(tgcmdPayload)
+					replyTo := tgcmd.C.Message().ReplyTo
+					if err != nil {
+						tgcmd.Output <- fmt.Sprintf("%v\n%s", err, USAGE_PIPE)
+						close(tgcmd.Output)
+					} else if cmdline == "" || replyTo == nil || (replyTo.Document == nil && replyTo.Text == "") {
+						tgcmd.Output <- USAGE_PIPE
+						close(tgcmd.Output)
+					} else {
+						if replyTo.Document != nil {
+							// stream the document to stdin. Stop downloading if the process exits early
+							reader, writer := io.Pipe()
+							go func(ctx context.Context, tgtoken string, fileId string) {
+								file, err := util.OpenTgFile(ctx, config.ConfigData.ApiUrl(), tgtoken, fileId)
+								if err != nil {
+									writer.CloseWithError(err)
+									return
+								}
+								defer file.Close()
+								_, err = io.Copy(writer, file)
+								writer.CloseWithError(err)
+							}(tgcmd.ctx, config.ConfigData.TelegramToken, replyTo.Document.FileID)
+							options.Stdin = reader
+						} else {
+							text := replyTo.Text
+							if !strings.HasSuffix(text, "\n") {
+								text += "\n"
+							}
+							options.Stdin = strings.NewReader(text)
+						}
+						command_run_with_options(tgcmd.ctx, tgcmd.C, executorSessions[config.DEFAULT_EXECUTOR],
+							tgcmd.Output, deleter, options, cmdline)
+					}
+				}
+			case "/watch":
+				{
+					intervalStr, cmdline := util.SplitFirstAndOthers(tgcmdPayload)
+					interval, err := parseWatchInterval(intervalStr)
+					session := executorSessions[activeSessions.GetActiveSessionName(tgcmd.Chatid)]
+					if !executor.IsOneshot(session.Executor) {
+						session = executorSessions[config.DEFAULT_EXECUTOR]
+					}
+					if err != nil || cmdline == "" {
+						tgcmd.Output <- USAGE_WATCH
+					} else if interval < WATCH_MIN_INTERVAL {
+						tgcmd.Output <- fmt.Sprintf("Interval must be at least %s", WATCH_MIN_INTERVAL)
+					} else if !session.Ready {
+						tgcmd.Output <- "The executor is not ready"
+					} else if msg, err := bot.Reply(tgcmd.C.Message(), fmt.Sprintf("Watch - %s", cmdline)); err != nil {
+						log.Printf("Failed to send watch message: %v", err)
+					} else {
+						startWatch(tgcmd.ctx, bot, msg, session, interval, cmdline)
+					}
+					close(tgcmd.Output)
+				}
+			case "/tail":
+				{
+					if tgcmdPayload == "" {
+						tgcmd.Output <- tailsList() + "\n" + USAGE_TAIL
+					} else if filepath, grep, n, err := parseTailPayload(tgcmdPayload); err != nil {
+						tgcmd.Output <- fmt.Sprintf("%v\n%s", err, USAGE_TAIL)
+					} else {
+						redactor := executorSessions[config.DEFAULT_EXECUTOR].redactorIfEnabled()
+						if _, err := startTail(tgcmd.ctx, bot, tgcmd.C.Chat(), filepath, grep, n, redactor); err != nil {
+							tgcmd.Output <- fmt.Sprintf("Failed to tail %s: %v", filepath, err)
+						}
+					}
+					close(tgcmd.Output)
+				}
+			case "/alert":
+				{
+					session := executorSessions[activeSessions.GetActiveSessionName(tgcmd.Chatid)]
+					action, args := util.SplitFirstAndOthers(tgcmdPayload)
+					switch action {
+					case "", "list":
+						tgcmd.Output <- alertsList(tgcmd.Chatid)
+					case "add":
+						sessionName, jobId := session.Name, 0
+						if flag, others := util.SplitFirstAndOthers(args); flag == "-j" {
+							idStr, pattern := util.SplitFirstAndOthers(others)
+							id, _ := strconv.Atoi(idStr)
+							if j := job.Get(id); j == nil || !j.Running() {
+								tgcmd.Output <- fmt.Sprintf("Job %s not found or has exited", idStr)
+								break
+							}
+							sessionName, jobId, args = "", id, pattern
+						}
+						if args == "" {
+							tgcmd.Output <- USAGE_ALERT
+						} else if rule, err := addAlert(tgcmd.Chatid, sessionName, jobId, args); err != nil {
+							tgcmd.Output <- fmt.Sprintf("Invalid regex: %v", err)
+						} else {
+							tgcmd.Output <- fmt.Sprintf("Alert %d added", rule.Id)
+						}
+					case "del":
+						if id, err := strconv.Atoi(args); err != nil || !delAlert(tgcmd.Chatid, id) {
+							tgcmd.Output <- fmt.Sprintf("Alert '%s' not found", args)
+						} else {
+							tgcmd.Output <- fmt.Sprintf("Alert %d deleted", id)
+						}
+					default:
+						tgcmd.Output <- USAGE_ALERT
+					}
+					close(tgcmd.Output)
+				}
+			// user's reply to a bot prompt
+			case "reply":
+				{
+					if replyTo := tgcmd.C.Message().ReplyTo; replyTo != nil && strings.HasPrefix(replyTo.Text, PROMPT_EDIT) {
+						if data, menu, err := handleEditReply(replyTo.Text, tgcmdPayload, fileRedactor()); err != nil {
+							tgcmd.Output <- fmt.Sprintf("Failed to edit: %v", err)
+						} else {
+							tgcmd.C.Reply(data, menu, tele.ModeHTML)
+						}
+					} else if replyTo != nil && strings.HasPrefix(replyTo.Text, PROMPT_VIEW_SEARCH) {
+						if data, menu, err := handleViewSearchPrompt(replyTo.Text, tgcmdPayload, fileRedactor()); err != nil {
+							tgcmd.Output <- err.Error()
+						} else {
+							tgcmd.C.Reply(data, menu, tele.ModeHTML)
+						}
+					} else if replyTo != nil && isPromptMessage(replyTo.Text) {
+						tgcmd.Output <- handleFilePrompt(replyTo.Text, tgcmdPayload)
+					} else {
+						tgcmd.Output <- MSG_INVALID
+					}
+					close(tgcmd.Output)
+				}
+			case "/mkdir":
+				{
+					if args, err := shlex.Split(tgcmdPayload); err != nil || len(args) == 0 {
+						tgcmd.Output <- USAGE_MKDIR
+					} else {
+						for _, dir := range args {
+							if err := os.MkdirAll(dir, 0755); err != nil {
+								tgcmd.Output <- fmt.Sprintf("Failed to mkdir %s: %v", dir, err)
+							} else {
+								tgcmd.Output <- fmt.Sprintf("Created %s", absPath(dir))
+							}
+						}
+					}
+					close(tgcmd.Output)
+				}
+			case "/rm":
+				{
+					args, err := shlex.Split(tgcmdPayload)
+					recursive := len(args) > 0 && args[0] == "-r"
+					if recursive {
+						args = args[1:]
+					}
+					if err != nil || len(args) == 0 {
+						tgcmd.Output <- USAGE_RM
+					} else {
+						for _, file := range args {
+							if recursive {
+								err = os.RemoveAll(file)
+							} else {
+								err = os.Remove(file)
+							}
+							if err != nil {
+								tgcmd.Output <- fmt.Sprintf("Failed to delete %s: %v", file, err)
+							} else {
+								tgcmd.Output <- fmt.Sprintf("Deleted %s", absPath(file))
+							}
+						}
+					}
+					close(tgcmd.Output)
+				}
+			case "/mv":
+				{
+					if args, err := shlex.Split(tgcmdPayload); err != nil || len(args) != 2 {
+						tgcmd.Output <- USAGE_MV
+					} else if dst, err := util.MovePath(args[0], args[1]); err != nil {
+						tgcmd.Output <- fmt.Sprintf("Failed to move %s: %v", args[0], err)
+					} else {
+						tgcmd.Output <- fmt.Sprintf("Moved to %s", absPath(dst))
+					}
+					close(tgcmd.Output)
+				}
+			case "/jobs":
+				{
+					close(tgcmd.Output)
+					data, menu := jobsMessage(tgcmd.Chatid)
+					tgcmd.C.Reply(data, menu, tele.NoPreview)
+				}
+			case "/resize":
+				{
+					session := executorSessions[activeSessions.GetActiveSessionName(tgcmd.Chatid)]
+					cols, rows := 0, 0
+					resizer, ok := session.Executor.(executor.Resizer)
+					if ok {
+						cols, rows = resizer.Size()
+					}
+					if cols == 0 {
+						tgcmd.Output <- fmt.Sprintf("Executor '%s' is not running in a pty", session.Executor.Name())
+					} else if tgcmdPayload == "" {
+						tgcmd.Output <- fmt.Sprintf("Size: %dx%d\n%s", cols, rows, USAGE_RESIZE)
+					} else if args := PTY_SIZE_REGEXP.FindStringSubmatch(tgcmdPayload); args == nil {
+						tgcmd.Output <- USAGE_RESIZE
+					} else {
+						cols, _ = strconv.Atoi(args[PTY_SIZE_REGEXP.SubexpIndex("cols")])
+						rows, _ = strconv.Atoi(args[PTY_SIZE_REGEXP.SubexpIndex("rows")])
+						if cols <= 0 || rows <= 0 || cols > 1000 || rows > 1000 {
+							tgcmd.Output <- MSG_INVALID
+						} else if err := resizer.Resize(cols, rows); err != nil {
+							tgcmd.Output <- fmt.Sprintf("Failed to resize: %v", err)
+						} else {
+							tgcmd.Output <- fmt.Sprintf("Resized to %dx%d", cols, rows)
+						}
+					}
+					close(tgcmd.Output)
+				}
 			case "/raw":
 				{
 					if tgcmdPayload == "" {
@@ -812,17 +1481,22 @@ main:
 				case TYPE_REPLY:
 					{
 						if session := executorSessions[sessionName]; session != nil {
+							ttl, _ := msg.C.Get("ttl").(int)
 							for _, data := range datas {
-								if msg.C.Get("replied") == nil {
-									msg.C.Reply(data, getExecutorMenu(session.Executor.Buttons()), tele.NoPreview)
+								var sent *tele.Message
+								if msg.C.Get("replied") == nil && msg.C.Message() != nil {
+									sent, _ = bot.Reply(msg.C.Message(), data, getExecutorMenu(session.Executor.Buttons()), tele.NoPreview)
 									msg.C.Set("replied", true)
 								} else {
-									msg.C.Send(data, getExecutorMenu(session.Executor.Buttons()), tele.NoPreview)
+									sent, _ = bot.Send(msg.C.Recipient(), data, getExecutorMenu(session.Executor.Buttons()), tele.NoPreview)
 								}
+								deleter.Schedule(sent, ttl)
 							}
 						}
 					}
 				case TYPE_CLOSE:
+					// the session may have been deleted already by /close, /closeall or /delexecutor
+					clearAlerts(sessionName, 0)
 					if executorSessions[sessionName] != nil {
 						delete(executorSessions, sessionName)
 						bot.Send(&tele.Chat{ID: msg.Chatid}, fmt.Sprintf("Executor '%s' closed", msg.Executor), tele.NoPreview)
@@ -842,9 +1516,11 @@ main:
 				default:
 					{
 						if isFromActiveSession {
+							ttl := executorTTL(msg.Executor)
 							for _, data := range datas {
-								bot.Send(&tele.Chat{ID: msg.Chatid}, data,
+								sent, _ := bot.Send(&tele.Chat{ID: msg.Chatid}, data,
 									getExecutorMenu(executorSessions[sessionName].Executor.Buttons()), tele.NoPreview)
+								deleter.Schedule(sent, ttl)
 							}
 						}
 					}
@@ -858,6 +1534,14 @@ main:
 	}
 }
 
+// Run a user's cmdline the way /run does: with session env, collected files and ttl of output.
+// Will take over output and be responsible for closing it
+func command_run_with_options(ctx context.Context, C tele.Context, session *TgExecutorSession,
+	output chan<- string, deleter *messageDeleter, options *runOptions, cmdline string) {
+	deleter.applyTTL(C, session.Executor.Name(), options)
+	command_run_collect(ctx, C, session, output, deleter, options, cmdline)
+}
+
 // Run cmdline using session's executor and pipe it's out to output.
 // Will take over output and be responsible for closing it
 func command_run(ctx context.Context, session *TgExecutorSession, output chan<- string, cmdline string) {
@@ -890,17 +1574,20 @@ func command_run(ctx context.Context, session *TgExecutorSession, output chan<-
 			cmdline = string([]byte{charByte})
 			isRaw = true
 		}
+		if isRaw {
+			session.recordInput(cmdline)
+		} else {
+			session.recordInput(cmdline + "\n")
+		}
 		if cmdOut := session.Executor.Exec(ctx, cmdline, isRaw); cmdOut == nil {
 			close(output)
 		} else {
+			redactor := session.newRedactor()
 			go func() {
 				defer close(output)
-				for {
-					if data, ok := <-cmdOut; !ok {
-						break
-					} else {
-						output <- data
-					}
+				for data := range redactor.Stream(cmdOut, session.Redacting) {
+					session.recordOutput(data)
+					output <- data
 				}
 			}()
 		}
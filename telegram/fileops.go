@@ -0,0 +1,166 @@
+package telegram
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/util"
+)
+
+// First line prefixes of ForceReply prompts sent by bot. User's reply to them is dispatched by prefix
+const PROMPT_RENAME = "Rename - "
+const PROMPT_MOVE = "Move - "
+const PROMPT_COPY = "Copy - "
+const PROMPT_CHMOD = "Chmod - "
+
+var promptPrefixes = []string{PROMPT_RENAME, PROMPT_MOVE, PROMPT_COPY, PROMPT_CHMOD, PROMPT_VIEW_SEARCH, PROMPT_EDIT}
+
+// Whether text is a prompt message sent by bot, to which user replies input
+func isPromptMessage(text string) bool {
+	return slices.ContainsFunc(promptPrefixes, func(prefix string) bool { return strings.HasPrefix(text, prefix) })
+}
+
+// Per-file action menu message. First line: "File - <path>"
+func fileMenu(filepath string) (string, *tele.ReplyMarkup) {
+	data := fmt.Sprintf("File - %s\n", filepath)
+	stat, err := os.Lstat(filepath)
+	if err != nil {
+		return data + fmt.Sprintf("Failed to stat: %v", err), &tele.ReplyMarkup{}
+	}
+	fileType := "file"
+	if stat.IsDir() {
+		fileType = "dir"
+	} else if stat.Mode()&os.ModeSymlink != 0 {
+		fileType = "symlink"
+		if target, err := os.Readlink(filepath); err == nil {
+			fileType += " -> " + target
+		}
+		// show info of link target
+		if targetStat, err := os.Stat(filepath); err == nil {
+			stat = targetStat
+		}
+	}
+	data += fmt.Sprintf("Type: %s\nSize: %s (%d bytes)\nMode: %s (%04o)\nModified: %s\n",
+		fileType, util.BytesSize(float64(stat.Size())), stat.Size(), stat.Mode(), stat.Mode().Perm(),
+		stat.ModTime().Format("2006-01-02 15:04:05"))
+	first := []tele.InlineButton{{Text: "↓ get", Data: "get_x"}, {Text: "view", Data: "view_x"}}
+	if stat.IsDir() {
+		first = []tele.InlineButton{{Text: "cd", Data: "cd_x"}, {Text: "archive ⇩", Data: "archive_x"}}
+	}
+	menu := &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{
+		append(first, tele.InlineButton{Text: "stat", Data: "stat_x"}, tele.InlineButton{Text: "delete", Data: "rm_ask"}),
+		{{Text: "rename", Data: "rename_x"}, {Text: "move", Data: "move_x"},
+			{Text: "copy", Data: "copy_x"}, {Text: "chmod", Data: "chmod_x"}},
+	}}
+	return data, menu
+}
+
+// Inline keyboard asking user to confirm deletion of file.
+// A non-empty dir (not symlink) can only be deleted with a separate recursive confirmation, like "/rm -r"
+func fileDeleteConfirmMenu(file string) *tele.ReplyMarkup {
+	if stat, err := os.Lstat(file); err == nil && stat.IsDir() {
+		if entries := countEntries(file); entries > 0 {
+			return &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{
+				{{Text: fmt.Sprintf("⚠ Delete dir and all %d entries in it", entries), Data: "rm_recursive"},
+					{Text: "Cancel", Data: "rm_no"}},
+			}}
+		}
+	}
+	return &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{
+		{{Text: "⚠ Confirm delete", Data: "rm_yes"}, {Text: "Cancel", Data: "rm_no"}},
+	}}
+}
+
+// Count all files and dirs inside dir (recursively)
+func countEntries(dir string) (count int) {
+	filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err == nil && file != dir {
+			count++
+		}
+		return nil
+	})
+	return
+}
+
+// Text of a ForceReply prompt asking input for action on filepath
+func filePrompt(action string, filepath string) string {
+	switch action {
+	case "rename":
+		return PROMPT_RENAME + filepath + "\nReply with the new name"
+	case "move":
+		return PROMPT_MOVE + filepath + "\nReply with the destination path (file or dir)"
+	case "copy":
+		return PROMPT_COPY + filepath + "\nReply with the destination path (file or dir)"
+	case "chmod":
+		return PROMPT_CHMOD + filepath + "\nReply with the octal mode, e.g. 644"
+	}
+	return ""
+}
+
+// Resolve a user input path against cwd
+func absPath(filepath string) string {
+	if path.IsAbs(filepath) {
+		return path.Clean(filepath)
+	}
+	cwd, _ := os.Getwd()
+	return path.Join(cwd, filepath)
+}
+
+// Perform the action of prompt with user's input. Return result text
+func handleFilePrompt(prompt string, input string) string {
+	firstLine, _, _ := strings.Cut(prompt, "\n")
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return MSG_INVALID
+	}
+	for _, prefix := range promptPrefixes {
+		if !strings.HasPrefix(firstLine, prefix) {
+			continue
+		}
+		filepath := strings.TrimPrefix(firstLine, prefix)
+		switch prefix {
+		case PROMPT_RENAME:
+			if strings.ContainsAny(input, `/\`) {
+				return "New name can not contain path separator. To move, use 'move'"
+			}
+			dst := path.Join(path.Dir(filepath), input)
+			if _, err := os.Lstat(dst); err == nil {
+				return fmt.Sprintf("%s already exists", dst)
+			}
+			if err := os.Rename(filepath, dst); err != nil {
+				return fmt.Sprintf("Failed to rename: %v", err)
+			}
+			return fmt.Sprintf("Renamed to %s", dst)
+		case PROMPT_MOVE:
+			if dst, err := util.MovePath(filepath, absPath(input)); err != nil {
+				return fmt.Sprintf("Failed to move: %v", err)
+			} else {
+				return fmt.Sprintf("Moved to %s", dst)
+			}
+		case PROMPT_COPY:
+			if dst, err := util.CopyPath(filepath, absPath(input)); err != nil {
+				return fmt.Sprintf("Failed to copy: %v", err)
+			} else {
+				return fmt.Sprintf("Copied to %s", dst)
+			}
+		case PROMPT_CHMOD:
+			mode, err := strconv.ParseUint(input, 8, 32)
+			if err != nil || mode > 0777 {
+				return fmt.Sprintf("Invalid mode '%s'", input)
+			}
+			if err := os.Chmod(filepath, os.FileMode(mode)); err != nil {
+				return fmt.Sprintf("Failed to chmod: %v", err)
+			}
+			return fmt.Sprintf("Mode of %s changed to %04o", filepath, mode)
+		}
+	}
+	return MSG_INVALID
+}
@@ -0,0 +1,193 @@
+package telegram
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/constants"
+	"github.com/sagan/tgshell/util"
+)
+
+const FILES_PAGE_SIZE = 30 // files per page. Must keep total buttons under tg's limit of 100
+const FILES_SORT_NAME = "name"
+const FILES_SORT_SIZE = "size"
+const FILES_SORT_MTIME = "mtime"
+
+var FILES_STATE_REGEXP = regexp.MustCompile(`^Sort: (?P<sort>\w+), Hidden: (?P<hidden>\w+), Page: (?P<page>\d+)/\d+$`)
+
+// State of a /files message. It's stored in the message text, so callbacks are stateless.
+type filesView struct {
+	Dir        string
+	Prefix     string
+	Sort       string
+	ShowHidden bool
+	Page       int // 0-based
+}
+
+type fileEntry struct {
+	name  string
+	isDir bool
+	size  int64 // -1 if unknown
+	mtime time.Time
+}
+
+func newFilesView(dir string, prefix string) *filesView {
+	return &filesView{Dir: dir, Prefix: prefix, Sort: FILES_SORT_NAME, ShowHidden: true}
+}
+
+// Parse the view state from the text of a /files message. Format:
+// "Files - <dir>", "Prefix: <prefix>", "Sort: <sort>, Hidden: <shown|hidden>, Page: <page>/<pages>"
+func parseFilesView(text string) *filesView {
+	lines := strings.Split(text, "\n")
+	view := newFilesView(strings.TrimPrefix(lines[0], "Files - "), "")
+	if len(lines) > 1 {
+		view.Prefix = strings.TrimPrefix(lines[1], "Prefix: ")
+	}
+	if len(lines) > 2 {
+		if args := FILES_STATE_REGEXP.FindStringSubmatch(lines[2]); args != nil {
+			view.Sort = args[FILES_STATE_REGEXP.SubexpIndex("sort")]
+			view.ShowHidden = args[FILES_STATE_REGEXP.SubexpIndex("hidden")] == "shown"
+			page, _ := strconv.Atoi(args[FILES_STATE_REGEXP.SubexpIndex("page")])
+			view.Page = max(page-1, 0)
+		}
+	}
+	return view
+}
+
+// Read, filter and sort files of dir
+func (v *filesView) entries() ([]*fileEntry, error) {
+	files, err := os.ReadDir(v.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*fileEntry
+	for _, file := range files {
+		if v.Prefix != "" && !strings.HasPrefix(file.Name(), v.Prefix) {
+			continue
+		}
+		if !v.ShowHidden && strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		entry := &fileEntry{name: file.Name(), isDir: file.IsDir(), size: -1}
+		if stat, err := os.Stat(path.Join(v.Dir, file.Name())); err == nil {
+			entry.mtime = stat.ModTime()
+			if !stat.IsDir() {
+				entry.size = stat.Size()
+			}
+		}
+		entries = append(entries, entry)
+	}
+	switch v.Sort {
+	case FILES_SORT_SIZE:
+		slices.SortStableFunc(entries, func(a, b *fileEntry) int { return cmp.Compare(b.size, a.size) })
+	case FILES_SORT_MTIME:
+		slices.SortStableFunc(entries, func(a, b *fileEntry) int { return b.mtime.Compare(a.mtime) })
+	}
+	return entries, nil
+}
+
+// Return the path of entry no (index of callback data) in the view.
+// The line of it in the message (lines) must still show the same name, in case dir was changed since listed
+func (v *filesView) entryPath(lines []string, index string) (string, error) {
+	no, err := strconv.Atoi(index)
+	if err != nil || no < 0 {
+		return "", fmt.Errorf(MSG_INVALID)
+	}
+	entries, err := v.entries()
+	if err != nil {
+		return "", err
+	}
+	if no >= len(entries) || !strings.HasSuffix(util.FindLineDataByFirstField(lines, index), "  "+entries[no].name) {
+		return "", fmt.Errorf("%s has changed since listed. To refresh, click \"cd .\"", v.Dir)
+	}
+	return path.Join(v.Dir, entries[no].name), nil
+}
+
+// Render message text and inline keyboard of current page
+func (v *filesView) render() (string, *tele.ReplyMarkup, error) {
+	entries, err := v.entries()
+	if err != nil {
+		return "", nil, err
+	}
+	pages := max((len(entries)+FILES_PAGE_SIZE-1)/FILES_PAGE_SIZE, 1)
+	v.Page = min(max(v.Page, 0), pages-1)
+	hidden := "shown"
+	if !v.ShowHidden {
+		hidden = "hidden"
+	}
+	data := fmt.Sprintf("Files - %s\nPrefix: %s\nSort: %s, Hidden: %s, Page: %d/%d\n%s\n\n",
+		v.Dir, v.Prefix, v.Sort, hidden, v.Page+1, pages, FILES_TIP)
+	chars := utf8.RuneCountInString(data)
+	var inlineKeyboard [][]tele.InlineButton
+	var inlineKeyboardRow []tele.InlineButton
+	for no := v.Page * FILES_PAGE_SIZE; no < len(entries) && no < (v.Page+1)*FILES_PAGE_SIZE; no++ {
+		entry := entries[no]
+		flag := "-"
+		size := "?"
+		if entry.isDir {
+			flag = "d"
+			size = "-"
+		} else if entry.size >= 0 {
+			size = util.BytesSizeAround(float64(entry.size))
+		}
+		filedata := fmt.Sprintf("%-2d %1s %4s  %s\n", no, flag, size, entry.name)
+		if newchars := utf8.RuneCountInString(filedata) + chars; newchars > constants.TG_TEXT_LIMIT {
+			break
+		} else {
+			data += filedata
+			chars = newchars
+		}
+		if entry.isDir {
+			inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
+				Text: fmt.Sprintf("cd %d", no),
+				Data: fmt.Sprintf("cd_%d", no),
+			})
+		} else {
+			inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
+				Text: fmt.Sprintf("⋯ %d", no),
+				Data: fmt.Sprintf("menu_%d", no),
+			})
+		}
+		if len(inlineKeyboardRow) >= constants.TG_ROW_BUTTONS {
+			inlineKeyboard = append(inlineKeyboard, inlineKeyboardRow)
+			inlineKeyboardRow = nil
+		}
+	}
+	if len(inlineKeyboardRow) > 0 {
+		inlineKeyboard = append(inlineKeyboard, inlineKeyboardRow)
+	}
+	inlineKeyboardRow = []tele.InlineButton{{Text: "cd .", Data: "cd_."}, {Text: "cd ..", Data: "cd_.."},
+		{Text: "⋯ .", Data: "menu_."}}
+	if v.Page > 0 {
+		inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{Text: "◀ Prev", Data: "page_prev"})
+	}
+	if v.Page < pages-1 {
+		inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{Text: "Next ▶", Data: "page_next"})
+	}
+	inlineKeyboard = append(inlineKeyboard, inlineKeyboardRow)
+	inlineKeyboardRow = nil
+	for _, sort := range []string{FILES_SORT_NAME, FILES_SORT_SIZE, FILES_SORT_MTIME} {
+		text := "by " + sort
+		if sort == v.Sort {
+			text = "• " + text
+		}
+		inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{Text: text, Data: "sort_" + sort})
+	}
+	if v.ShowHidden {
+		inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{Text: "hide .*", Data: "hidden_toggle"})
+	} else {
+		inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{Text: "show .*", Data: "hidden_toggle"})
+	}
+	inlineKeyboard = append(inlineKeyboard, inlineKeyboardRow)
+	return data, &tele.ReplyMarkup{InlineKeyboard: inlineKeyboard}, nil
+}
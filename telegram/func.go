@@ -4,10 +4,16 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
+	fpath "path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sagan/tgshell/config"
 	"github.com/sagan/tgshell/constants"
+	"github.com/sagan/tgshell/executor"
+	"github.com/sagan/tgshell/util"
 	tele "gopkg.in/telebot.v3"
 )
 
@@ -42,7 +48,11 @@ func getExecutorMenu(buttons []string) *tele.ReplyMarkup {
 // Set the Chatid and Output of tgcmd, send it to commander, read Output and send back to user
 func runCommand(ctx context.Context, c tele.Context, commander chan *TgCommad,
 	messenger chan<- *TgGlobalMsg, command string, payload string) error {
-	log.Printf("Command name=%s, payload=%s", command, payload)
+	if command == "/setenv" && strings.HasPrefix(payload, "-s") {
+		log.Printf("Command name=%s, payload=%s", command, "-s "+REDACTED)
+	} else {
+		log.Printf("Command name=%s, payload=%s", command, payload)
+	}
 	output := make(chan string, 5)
 	commander <- &TgCommad{
 		ctx:     ctx,
@@ -69,6 +79,58 @@ func runCommand(ctx context.Context, c tele.Context, commander chan *TgCommad,
 	return nil
 }
 
+// Options of /run
+type runOptions struct {
+	executor.ExecOptions
+	Collect string // glob of files to send back after cmdline exits
+	TTL     int    // seconds. Delete output messages after it. 0: use executor config
+	TTLCmd  bool   // also delete the user's command message after TTL
+}
+
+// Parse the leading options of /run payload, e.g. "-t 300 make all".
+// The remaining part is returned verbatim as cmdline. Use "--" to end options.
+func parseRunOptions(payload string) (options *runOptions, cmdline string, err error) {
+	options = &runOptions{}
+	cmdline = strings.TrimSpace(payload)
+	for strings.HasPrefix(cmdline, "-") {
+		var flag, value string
+		flag, cmdline = util.SplitFirstAndOthers(cmdline)
+		if flag == "--" {
+			break
+		}
+		flag, value, hasValue := strings.Cut(flag, "=")
+		if flag == "--ttl-cmd" {
+			options.TTLCmd = true
+			continue
+		}
+		if !hasValue {
+			value, cmdline = util.SplitFirstAndOthers(cmdline)
+		}
+		switch flag {
+		case "-t", "--timeout":
+			timeout, err := strconv.Atoi(value)
+			if err != nil || timeout < 0 {
+				return nil, "", fmt.Errorf("invalid timeout '%s'", value)
+			}
+			options.Timeout = &timeout
+		case "--ttl":
+			ttl, err := strconv.Atoi(value)
+			if err != nil || ttl <= 0 {
+				return nil, "", fmt.Errorf("invalid ttl '%s'", value)
+			}
+			options.TTL = ttl
+		case "-c", "--collect":
+			if value == "" {
+				return nil, "", fmt.Errorf("empty collect glob")
+			}
+			options.Collect = value
+		default:
+			return nil, "", fmt.Errorf("unknown option '%s'", flag)
+		}
+	}
+	return
+}
+
 // ignore messages that arrived too late, or too early (which means server time may be incorrect)
 func ignoreBelatedMiddleware(seconds int64, msg string) tele.MiddlewareFunc {
 	return func(next tele.HandlerFunc) tele.HandlerFunc {
@@ -121,3 +183,18 @@ func setCommands(bot *tele.Bot, chatid int64) error {
 		ChatID: chatid,
 	})
 }
+
+// A local file to send. If the Bot API server runs in --local mode on the same host,
+// it reads the file directly via file:// uri instead of uploading
+func localFile(filepath string) tele.File {
+	if config.ConfigData.TelegramApiLocal {
+		if abspath, err := fpath.Abs(filepath); err == nil {
+			abspath = fpath.ToSlash(abspath)
+			if !strings.HasPrefix(abspath, "/") {
+				abspath = "/" + abspath // windows
+			}
+			return tele.FromURL((&url.URL{Scheme: "file", Path: abspath}).String())
+		}
+	}
+	return tele.FromDisk(filepath)
+}
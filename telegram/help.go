@@ -37,9 +37,28 @@ const BUTTONS_TIP = `- Click 'Del' to delete
 const CMDS_TIP = `- Click 'Del' to delete
 - To add new, use /addcmd`
 
-const FILES_TIP = `- Click '↓' to get
+const FILES_TIP = `- Click '⋯' for file actions (get, delete, rename...)
+- Click 'cd .' to refresh, '⋯ .' for actions of this dir
 - To narrow, use /files <prefix>`
 
+const RECORDINGS_TIP = `- Click '↓' to get
+- Replay with asciinema: asciinema play <file>
+- To record, send /record on`
+
+const JOBS_TIP = `- Click 'Tail' to get latest output
+- Click 'Attach' to stream live output
+- Click '↓' to get full output file
+- To start new, use /bg <cmdline>`
+
+const ENV_TIP = `- Session env applies to cmdlines of oneshot executors
+- Config env applies to all processes of the executor
+- To set, use /setenv [-s] KEY=VALUE (-s: secure)
+- To unset, use /unsetenv KEY`
+
+const ALERTS_TIP = `- Alerts notify you when a output line matches
+- To add, use /alert add [-j <job_id>] <regex>
+- To delete, use /alert del <id>`
+
 const EXECUTORS_TIP = `- Click 'Del' to delete
 - To refresh, send /executors
 - To add new, use /addexecutor`
@@ -0,0 +1,173 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/config"
+	"github.com/sagan/tgshell/constants"
+	"github.com/sagan/tgshell/job"
+	"github.com/sagan/tgshell/util"
+	"github.com/sagan/tgshell/util/redact"
+)
+
+const JOBS_LIST_MAX = 20
+const JOB_TAIL_SIZE = 3000 // bytes
+
+// Return the /jobs message and it's inline keyboard
+func jobsMessage(chatid int64) (string, *tele.ReplyMarkup) {
+	jobs := job.List()
+	if len(jobs) > JOBS_LIST_MAX {
+		jobs = jobs[len(jobs)-JOBS_LIST_MAX:]
+	}
+	data := fmt.Sprintf("Jobs (%d)\n%s\n\n", len(jobs), JOBS_TIP)
+	var inlineKeyboard [][]tele.InlineButton
+	for _, j := range jobs {
+		data += j.String() + "\n"
+		inlineKeyboardRow := []tele.InlineButton{{
+			Text: fmt.Sprintf("Tail %d", j.Id),
+			Data: fmt.Sprintf("tail_%d", j.Id),
+		}}
+		if j.Running() {
+			if j.Attached(chatid) {
+				inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
+					Text: fmt.Sprintf("Detach %d", j.Id),
+					Data: fmt.Sprintf("detach_%d", j.Id),
+				})
+			} else {
+				inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
+					Text: fmt.Sprintf("Attach %d", j.Id),
+					Data: fmt.Sprintf("attach_%d", j.Id),
+				})
+			}
+			inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
+				Text: fmt.Sprintf("Kill %d", j.Id),
+				Data: fmt.Sprintf("kill_%d", j.Id),
+			})
+		}
+		inlineKeyboardRow = append(inlineKeyboardRow, tele.InlineButton{
+			Text: fmt.Sprintf("↓ %d", j.Id),
+			Data: fmt.Sprintf("get_%d", j.Id),
+		})
+		inlineKeyboard = append(inlineKeyboard, inlineKeyboardRow)
+	}
+	return data, &tele.ReplyMarkup{InlineKeyboard: inlineKeyboard}
+}
+
+// Stream job output to chat until job exits or detached. Output is batched every second.
+// Jobs are run by the default executor, so output is redacted following it's session
+func attachJob(j *job.Job, session *TgExecutorSession, chatid int64, messenger chan<- *TgGlobalMsg) error {
+	subscriber, err := j.Attach(chatid)
+	if err != nil {
+		return err
+	}
+	output := session.newRedactor().Stream(subscriber, session.Redacting)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		var buf strings.Builder
+		flush := func() {
+			if buf.Len() > 0 {
+				messenger <- &TgGlobalMsg{Type: TYPE_GLOBAL, Chatid: chatid, Data: buf.String()}
+				buf.Reset()
+			}
+		}
+		for {
+			select {
+			case data, ok := <-output:
+				if !ok {
+					flush()
+					return
+				}
+				buf.WriteString(data)
+				if buf.Len() >= constants.TG_TEXT_LIMIT {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+	return nil
+}
+
+// Return the text with job output redacted following the default executor session
+func redactJobOutput(session *TgExecutorSession, data string) string {
+	if !session.Redacting() {
+		return data
+	}
+	return session.newRedactor().RedactText(data)
+}
+
+// Send the output file of job. If output of session is redacted, a redacted copy is sent.
+// Output larger than upload limit is sent in parts
+func sendJobOutput(ctx context.Context, bot *tele.Bot, C tele.Context, session *TgExecutorSession, j *job.Job) {
+	file := j.OutputPath
+	if redactor := session.redactorIfEnabled(); redactor != nil {
+		tmpdir, err := os.MkdirTemp("", "tgshell-job-")
+		if err != nil {
+			C.Reply(fmt.Sprintf("Failed to create temp dir: %v", err))
+			return
+		}
+		defer os.RemoveAll(tmpdir)
+		file = path.Join(tmpdir, path.Base(j.OutputPath))
+		if err := redactFile(j.OutputPath, file, redactor); err != nil {
+			C.Reply(fmt.Sprintf("Failed to redact %s: %v", j.OutputPath, err))
+			return
+		}
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		C.Reply(fmt.Sprintf("Failed to read %s: %v", j.OutputPath, err))
+		return
+	}
+	C.Reply(fmt.Sprintf("Sending %s", j.OutputPath))
+	if limit := config.ConfigData.UploadLimit(); stat.Size() > limit {
+		C.Reply(fmt.Sprintf("Output of job %d (%s) exceeds the upload limit (%s), sending it in parts", j.Id,
+			util.BytesSize(float64(stat.Size())), util.BytesSize(float64(limit))))
+		sendSplitFile(ctx, bot, C, file)
+	} else {
+		sendFileWithProgress(ctx, bot, C, file)
+	}
+}
+
+// Write the redacted content of src file to dst
+func redactFile(src string, dst string, redactor *redact.Redactor) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if _, err := io.WriteString(out, redactor.Redact(string(buf[:n]))); err != nil {
+				out.Close()
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if _, err := io.WriteString(out, redactor.Flush()); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
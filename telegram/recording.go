@@ -0,0 +1,108 @@
+package telegram
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sagan/tgshell/config"
+	"github.com/sagan/tgshell/executor"
+	"github.com/sagan/tgshell/util/asciicast"
+)
+
+const RECORDINGS_DIR = "recordings" // relative to config.ConfigPath
+const RECORDING_EXT = ".cast"
+
+func recordingsDir() string {
+	return path.Join(config.ConfigPath, RECORDINGS_DIR)
+}
+
+// Start recording session input & output to a new asciicast file. Return the file path
+func (s *TgExecutorSession) StartRecording() (string, error) {
+	if recorder := s.recorder.Load(); recorder != nil {
+		return recorder.Path, nil
+	}
+	if err := os.MkdirAll(recordingsDir(), 0700); err != nil {
+		return "", err
+	}
+	cols, rows := 0, 0
+	if resizer, ok := s.Executor.(executor.Resizer); ok {
+		cols, rows = resizer.Size()
+	}
+	if cols == 0 {
+		if executorConfig := config.GetExecutor(s.Executor.Name()); executorConfig != nil {
+			cols, rows = executorConfig.PtySize()
+		} else {
+			cols, rows = config.PtyExecutorConfig.PtySize()
+		}
+	}
+	filename := fmt.Sprintf("%s_%s%s", s.Name, time.Now().Format("20060102-150405"), RECORDING_EXT)
+	recorder, err := asciicast.NewRecorder(path.Join(recordingsDir(), filename), cols, rows,
+		fmt.Sprintf("tgshell %s", s.Executor.Name()))
+	if err != nil {
+		return "", err
+	}
+	s.recorder.Store(recorder)
+	return recorder.Path, nil
+}
+
+// Stop recording if it's on. Return the recorded file path
+func (s *TgExecutorSession) StopRecording() string {
+	if recorder := s.recorder.Swap(nil); recorder != nil {
+		recorder.Close()
+		return recorder.Path
+	}
+	return ""
+}
+
+// Return the current recording file path, or empty string if not recording
+func (s *TgExecutorSession) Recording() string {
+	if recorder := s.recorder.Load(); recorder != nil {
+		return recorder.Path
+	}
+	return ""
+}
+
+func (s *TgExecutorSession) recordInput(data string) {
+	if recorder := s.recorder.Load(); recorder != nil {
+		recorder.Input(data)
+	}
+}
+
+func (s *TgExecutorSession) recordOutput(data string) {
+	if recorder := s.recorder.Load(); recorder != nil {
+		recorder.Output(data)
+	}
+}
+
+// Close executor and stop recording
+func (s *TgExecutorSession) Close() {
+	s.Executor.Close()
+	s.StopRecording()
+}
+
+// Return all recording files, latest first
+func listRecordings() (recordings []os.FileInfo, err error) {
+	entries, err := os.ReadDir(recordingsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), RECORDING_EXT) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			recordings = append(recordings, info)
+		}
+	}
+	slices.SortFunc(recordings, func(a, b os.FileInfo) int {
+		return b.ModTime().Compare(a.ModTime())
+	})
+	return
+}
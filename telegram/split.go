@@ -0,0 +1,118 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/config"
+	"github.com/sagan/tgshell/util"
+)
+
+// Serialize reassembly of split parts, which are downloaded concurrently
+var splitJoinLock sync.Mutex
+
+func sendSplitManifest(C tele.Context, manifest *util.SplitManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return C.Reply(&tele.Document{File: tele.FromReader(bytes.NewReader(data)),
+		FileName: manifest.Name + util.SPLIT_MANIFEST_SUFFIX,
+		Caption:  fmt.Sprintf("Manifest of %s: %d parts", manifest.Name, len(manifest.Parts))})
+}
+
+// Max size of split parts. Parts must be both sendable and downloadable by bot
+func splitPartSize() int64 {
+	return min(config.ConfigData.UploadLimit(), config.ConfigData.DownloadLimit())
+}
+
+// Send a file as numbered parts, preceded by a manifest of sizes and SHA-256 checksums.
+// Parts are at most the smaller of upload and download limits, so that sending the manifest
+// and parts back to bot reassembles the file
+func sendSplitFile(ctx context.Context, bot *tele.Bot, C tele.Context, filepath string) {
+	C.Reply(fmt.Sprintf("Calculating checksums of %s", filepath))
+	manifest, err := util.NewSplitManifest(filepath, splitPartSize())
+	if err != nil {
+		C.Reply(fmt.Sprintf("Failed to read %s: %v", filepath, err))
+		return
+	}
+	if err := sendSplitManifest(C, manifest); err != nil {
+		C.Reply(fmt.Sprintf("Failed to send manifest: %v", err))
+		return
+	}
+	f, err := os.Open(filepath)
+	if err != nil {
+		C.Reply(fmt.Sprintf("Failed to read %s: %v", filepath, err))
+		return
+	}
+	defer f.Close()
+	t := startTransfer(ctx, bot, C.Chat(), "Sending", filepath, manifest.Size)
+	offset := int64(0)
+	for _, part := range manifest.Parts {
+		err := C.Reply(&tele.Document{File: tele.FromReader(t.Wrap(io.NewSectionReader(f, offset, part.Size))),
+			FileName: part.Name})
+		if err != nil {
+			t.Finish(fmt.Errorf("failed to send %s: %w", part.Name, err))
+			return
+		}
+		offset += part.Size
+	}
+	t.Finish(nil)
+	C.Reply(fmt.Sprintf("Sent %s (%s) in %d parts of at most %s. SHA-256: %s\n"+
+		"To reassemble, send the manifest and all parts to bot", manifest.Name, util.BytesSize(float64(manifest.Size)),
+		len(manifest.Parts), util.BytesSize(float64(splitPartSize())), manifest.Sha256))
+}
+
+// Check a file saved to dir from tg: if it's a split part or manifest, and the manifest exists,
+// verify it and reassemble the file once all parts are present. Return progress text.
+// ok is false if the file is not related to a split file
+func handleSplitUpload(dir string, filename string) (result string, ok bool) {
+	name, index, isPart := util.ParseSplitPartName(filename)
+	if !isPart {
+		if !strings.HasSuffix(filename, util.SPLIT_MANIFEST_SUFFIX) {
+			return "", false
+		}
+		name = strings.TrimSuffix(filename, util.SPLIT_MANIFEST_SUFFIX)
+	}
+	splitJoinLock.Lock()
+	defer splitJoinLock.Unlock()
+	manifest, err := util.ReadSplitManifest(dir, name)
+	if err != nil {
+		if !isPart {
+			return fmt.Sprintf("Failed to read manifest %s: %v", filename, err), true
+		}
+		return "", false // no manifest (yet). Treat as an ordinary file
+	}
+	if isPart {
+		if index > len(manifest.Parts) {
+			return fmt.Sprintf("Part %s is not in the manifest of %s", filename, name), true
+		}
+		if err := manifest.VerifyPart(dir, index); err != nil {
+			return fmt.Sprintf("Part %d/%d of %s is corrupted: %v", index, len(manifest.Parts), name, err), true
+		}
+	}
+	missing := manifest.MissingParts(dir)
+	if len(missing) > 0 {
+		received := len(manifest.Parts) - len(missing)
+		if isPart {
+			return fmt.Sprintf("Received part %d/%d of %s (verified). %d/%d parts received",
+				index, len(manifest.Parts), name, received, len(manifest.Parts)), true
+		}
+		return fmt.Sprintf("Received manifest of %s (%s). %d/%d parts received",
+			name, util.BytesSize(float64(manifest.Size)), received, len(manifest.Parts)), true
+	}
+	dst, err := manifest.Join(dir)
+	if err != nil {
+		return fmt.Sprintf("Failed to reassemble %s: %v", name, err), true
+	}
+	return fmt.Sprintf("Reassembled %s (%s) from %d parts. SHA-256 verified: %s",
+		dst, util.BytesSize(float64(manifest.Size)), len(manifest.Parts), manifest.Sha256), true
+}
@@ -0,0 +1,288 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/constants"
+	"github.com/sagan/tgshell/util"
+	"github.com/sagan/tgshell/util/redact"
+)
+
+const TAIL_INTERVAL = 2 * time.Second    // poll interval, also avoids hitting tg message edit rate limit
+const TAIL_MAX_DURATION = 12 * time.Hour // tail stops automatically after it
+const TAIL_DEFAULT_LINES = 10            // initial lines shown
+const TAIL_MAX_LINES = 100               // max initial lines
+const TAIL_INITIAL_READ = 64 * 1024      // bytes read from end of file to find initial lines
+const TAIL_READ_LIMIT = 1024 * 1024      // max bytes read per poll. Excess is skipped
+const TAIL_OUTPUT_LIMIT = constants.TG_TEXT_LIMIT - 500
+
+const USAGE_TAIL = "Usage: /tail [-g regexp] <file> [n]\n" +
+	"Follow a file like tail -f, showing the last n (default 10) lines first. -g: only show lines matching regexp.\n" +
+	"New lines are batched into a live message, which rolls over to a new one when full. Send /tail to list tails"
+
+// Follow a file, editing a live message with new lines
+type tail struct {
+	Id       int
+	Path     string
+	Grep     *regexp.Regexp
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var (
+	tails      = map[int]*tail{}
+	lastTailId int
+	tailsLock  sync.Mutex
+)
+
+// Parse "/tail [-g regexp] <file> [n]" payload
+func parseTailPayload(payload string) (filepath string, grep *regexp.Regexp, n int, err error) {
+	args := strings.Fields(payload)
+	if len(args) >= 2 && args[0] == "-g" {
+		if grep, err = regexp.Compile(args[1]); err != nil {
+			return "", nil, 0, err
+		}
+		args = args[2:]
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return "", nil, 0, fmt.Errorf("invalid args")
+	}
+	n = TAIL_DEFAULT_LINES
+	if len(args) == 2 {
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 0 {
+			return "", nil, 0, fmt.Errorf("invalid lines number '%s'", args[1])
+		}
+		n = min(n, TAIL_MAX_LINES)
+	}
+	return absPath(args[0]), grep, n, nil
+}
+
+// Start following filepath in chat. redactor is nil if output is not redacted
+func startTail(ctx context.Context, bot *tele.Bot, chat tele.Recipient, filepath string, grep *regexp.Regexp,
+	n int, redactor *redact.Redactor) (*tail, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("not a regular file")
+	}
+	tailsLock.Lock()
+	lastTailId++
+	t := &tail{Id: lastTailId, Path: filepath, Grep: grep, stop: make(chan struct{})}
+	tails[t.Id] = t
+	tailsLock.Unlock()
+	go t.run(ctx, bot, chat, f, stat, n, redactor)
+	return t, nil
+}
+
+func getTail(id int) *tail {
+	tailsLock.Lock()
+	defer tailsLock.Unlock()
+	return tails[id]
+}
+
+func tailsList() string {
+	tailsLock.Lock()
+	defer tailsLock.Unlock()
+	if len(tails) == 0 {
+		return "No active tails"
+	}
+	str := "Tails:\n"
+	for _, t := range tails {
+		str += fmt.Sprintf("%d: %s\n", t.Id, t.Path)
+	}
+	return str
+}
+
+func (t *tail) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+}
+
+// Return the last n lines of content
+func lastLines(content []byte, n int) []byte {
+	content = bytes.TrimSuffix(content, []byte("\n"))
+	for i := len(content) - 1; i >= 0; i-- {
+		if content[i] == '\n' {
+			if n--; n == 0 {
+				return content[i+1:]
+			}
+		}
+	}
+	return content
+}
+
+func (t *tail) run(ctx context.Context, bot *tele.Bot, chat tele.Recipient, f *os.File, stat os.FileInfo,
+	n int, redactor *redact.Redactor) {
+	defer func() {
+		tailsLock.Lock()
+		delete(tails, t.Id)
+		tailsLock.Unlock()
+		f.Close()
+	}()
+	output := ""  // content of the live message
+	partial := "" // incomplete last line
+	var msg *tele.Message
+	render := func(status string) {
+		text := t.text(output, status)
+		menu := &tele.ReplyMarkup{}
+		if status == "" {
+			menu.InlineKeyboard = [][]tele.InlineButton{{{Text: "Stop", Data: fmt.Sprintf("stop_%d", t.Id)}}}
+		}
+		if msg == nil {
+			msg, _ = bot.Send(chat, text, menu, tele.NoPreview)
+		} else {
+			bot.Edit(msg, text, menu, tele.NoPreview)
+		}
+	}
+	// append lines to output, rolling over to a new message if it's full
+	appendOutput := func(added string) {
+		if len(output)+len(added) > TAIL_OUTPUT_LIMIT && output != "" {
+			// live message is full: keep it as is and roll over to a new one
+			render("continued below")
+			msg, output = nil, ""
+		}
+		if runes := []rune(added); len(runes) > TAIL_OUTPUT_LIMIT {
+			added = "...(skipped)\n" + string(runes[len(runes)-TAIL_OUTPUT_LIMIT:])
+		}
+		output += added
+	}
+	// append complete lines of data to output. Return whether output changed
+	appendData := func(data string) bool {
+		data = partial + data
+		lastNewline := strings.LastIndex(data, "\n")
+		if lastNewline == -1 {
+			partial = data
+			return false
+		}
+		data, partial = data[:lastNewline+1], data[lastNewline+1:]
+		if redactor != nil {
+			data = redactor.Redact(data)
+		}
+		added := ""
+		for _, line := range strings.SplitAfter(data, "\n") {
+			if line != "" && (t.Grep == nil || t.Grep.MatchString(line)) {
+				added += line
+			}
+		}
+		if added == "" {
+			return false
+		}
+		appendOutput(added)
+		return true
+	}
+	// terminate the incomplete last line of old content, so it's not lost
+	flushPartial := func() bool {
+		if partial == "" {
+			return false
+		}
+		return appendData("\n")
+	}
+	offset := stat.Size()
+	start := max(offset-TAIL_INITIAL_READ, 0)
+	if n > 0 {
+		buf := make([]byte, offset-start)
+		if _, err := f.ReadAt(buf, start); err == nil || err == io.EOF {
+			if content := lastLines(buf, n); len(content) > 0 {
+				appendData(string(content) + "\n")
+			}
+		}
+	}
+	render("")
+	ticker := time.NewTicker(TAIL_INTERVAL)
+	defer ticker.Stop()
+	deadline := time.NewTimer(TAIL_MAX_DURATION)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			changed := false
+			// detect rotation (path now refers to another file) or truncation
+			if newStat, err := os.Stat(t.Path); err == nil && !os.SameFile(stat, newStat) {
+				if newFile, err := os.Open(t.Path); err == nil {
+					changed = t.readNew(f, &offset, appendData) || changed // rest of the old file
+					flushPartial()
+					f.Close()
+					f, stat, offset = newFile, newStat, 0
+					appendOutput("--- file rotated ---\n")
+					changed = true
+				}
+			} else if err == nil && newStat.Size() < offset {
+				flushPartial()
+				offset = 0
+				appendOutput("--- file truncated ---\n")
+				changed = true
+			}
+			if t.readNew(f, &offset, appendData) || changed {
+				render("")
+			}
+		case <-t.stop:
+			render("stopped")
+			return
+		case <-deadline.C:
+			render(fmt.Sprintf("stopped after max duration %s", TAIL_MAX_DURATION))
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Read data appended to f since offset. Return whether output changed
+func (t *tail) readNew(f *os.File, offset *int64, appendData func(string) bool) bool {
+	stat, err := f.Stat()
+	if err != nil || stat.Size() <= *offset {
+		return false
+	}
+	skipped := int64(0)
+	if size := stat.Size() - *offset; size > TAIL_READ_LIMIT {
+		skipped = size - TAIL_READ_LIMIT
+		*offset += skipped
+	}
+	buf := make([]byte, stat.Size()-*offset)
+	n, err := f.ReadAt(buf, *offset)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	*offset += int64(n)
+	data := string(buf[:n])
+	if skipped > 0 {
+		data = fmt.Sprintf("\n...(%s skipped)\n", util.BytesSize(float64(skipped))) + data
+	}
+	return appendData(data)
+}
+
+// Message text. First line: "Tail <id> - <path>"
+func (t *tail) text(output string, status string) string {
+	header := fmt.Sprintf("Tail %d - %s", t.Id, t.Path)
+	if t.Grep != nil {
+		header += "\nGrep: " + t.Grep.String()
+	}
+	header += "\n" + time.Now().Format("2006-01-02 15:04:05")
+	if status != "" {
+		header += " (" + status + ")"
+	}
+	if output == "" {
+		output = "(waiting for new lines)"
+	}
+	return header + "\n\n" + strings.TrimRight(output, "\n")
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	tele "gopkg.in/telebot.v3"
@@ -14,12 +16,20 @@ import (
 	"github.com/sagan/tgshell/constants"
 	"github.com/sagan/tgshell/executor"
 	"github.com/sagan/tgshell/util"
+	"github.com/sagan/tgshell/util/asciicast"
 )
 
 type TgExecutorSession struct {
 	Executor executor.Executor
 	Chatid   int64
 	Ready    bool
+	Name     string                             // session name
+	recorder atomic.Pointer[asciicast.Recorder] // non-nil if recording
+	env      []*sessionEnv                      // user set env. Only accessed in event loop
+	// secure values of env, updated with env. Read by redactors in other goroutines
+	envSecrets atomic.Pointer[[]string]
+	// output redaction. 1: on, -1: off, 0: follow executor config
+	redactMode atomic.Int32
 }
 
 type TgCommad struct {
@@ -53,6 +63,19 @@ var commands = [][4]string{
 	{"close", "Close (active) executor", "Usage: /close [name]", "1"},
 	{"executor", "Display or use executor(s)", "Usage: /executor [name]", "1"},
 	{"run", "Run cmdline in active executor", USAGE_RUN, "0"},
+	{"bg", "Run cmdline as a background job", USAGE_BG, "0"},
+	{"pipe", "Run cmdline with replied document or text as stdin", USAGE_PIPE, "0"},
+	{"watch", "Run cmdline periodically and display latest output", USAGE_WATCH, "0"},
+	{"tail", "Follow a file like tail -f", USAGE_TAIL, "0"},
+	{"alert", "Manage output-triggered alerts", USAGE_ALERT, "0"},
+	{"mkdir", "Create dirs", USAGE_MKDIR, "0"},
+	{"rm", "Delete files or dirs", USAGE_RM, "0"},
+	{"mv", "Move or rename file or dir", USAGE_MV, "0"},
+	{"env", "Display env of active executor session", "", "0"},
+	{"redact", "Toggle output redaction of active executor session", USAGE_REDACT, "0"},
+	{"setenv", "Set env of active executor session", USAGE_SETENV, "0"},
+	{"unsetenv", "Unset env of active executor session", USAGE_UNSETENV, "0"},
+	{"jobs", "Manage background jobs", "", "0"},
 	{"addcmd", "Add a custom command", USAGE_ADDCMD, "0"},
 	{"delcmd", "Delete a custom command", USAGE_DELCMD, "0"},
 	{"addexecutor", "Add a executor", USAGE_ADDEXECUTOR, "0"},
@@ -62,9 +85,14 @@ var commands = [][4]string{
 	{"delbtn", "Delete a button of active executor", USAGE_DELBTN, "0"},
 	{"clearbtn", "Delete all button of a executor", USAGE_CLEARBTN, "0"},
 	{"getfile", "Download a file from server", USAGE_GETFILE, "0"},
+	{"view", "View a text file in pages", USAGE_VIEW, "0"},
+	{"edit", "Edit a text file", USAGE_EDIT, "0"},
 	{"resetsecret", "Reset services secret", "", "0"},
 	{"refresh", "Refresh bot", "", "0"},
 	{"raw", "Send raw input", USAGE_RAW, "0"},
+	{"resize", "Resize pty of active executor", USAGE_RESIZE, "0"},
+	{"record", "Record active executor session", USAGE_RECORD, "0"},
+	{"recordings", "Manage session recordings", "", "0"},
 	{"pwd", "Get current working directory", "", "0"},
 	{"cd", "Change current working directory", USAGE_CD, "0"},
 	{"executors", "Manage executors", "", "0"},
@@ -130,13 +158,16 @@ func Start(ctx context.Context) {
 		config.DEFAULT_EXECUTOR: {
 			Executor: shell,
 			Ready:    true,
+			Name:     config.DEFAULT_EXECUTOR,
 		},
 	}
 	// chatid => active executor session name
 	var activeSessions = TgActiveSessions{}
 	bot, err := tele.NewBot(tele.Settings{
+		URL:    config.ConfigData.ApiUrl(),
 		Token:  config.ConfigData.TelegramToken,
 		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
+		Client: &http.Client{Timeout: constants.TG_HTTP_TIMEOUT * time.Second},
 	})
 	if err != nil {
 		log.Fatalf("Failed to init bot: %v", err)
@@ -166,6 +197,10 @@ func Start(ctx context.Context) {
 
 	// direct cmdline or custom tg cmd.
 	bot.Handle(tele.OnText, func(c tele.Context) error {
+		if replyTo := c.Message().ReplyTo; replyTo != nil && replyTo.Sender != nil &&
+			replyTo.Sender.ID == bot.Me.ID && isPromptMessage(replyTo.Text) {
+			return runCommand(ctx, c, commander, messenger, "reply", c.Message().Text)
+		}
 		cmdline := strings.TrimSpace(c.Message().Text)
 		command, payload := util.SplitFirstAndOthers(cmdline)
 		if !strings.HasPrefix(command, "/executor_") {
@@ -177,14 +212,16 @@ func Start(ctx context.Context) {
 					}
 				}
 			}
-			command = "/run"
+			// implicit run. Unlike explicit /run, the options of cmdline are not parsed
+			command = "cmdline"
 			payload = cmdline
 		}
 		return runCommand(ctx, c, commander, messenger, command, payload)
 	})
 
 	log.Printf("bot is now running")
-	go event_loop(ctx, bot, servicesProxy, activeSessions, executorSessions, commander, messenger)
+	deleter := newMessageDeleter(ctx, bot)
+	go event_loop(ctx, bot, servicesProxy, activeSessions, executorSessions, commander, messenger, deleter)
 	bot.Start()
 }
 
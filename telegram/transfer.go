@@ -0,0 +1,193 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/config"
+	"github.com/sagan/tgshell/util"
+)
+
+const TRANSFER_REFRESH_INTERVAL = 3 * time.Second // avoid hitting tg message edit rate limit
+const TRANSFER_BAR_WIDTH = 20
+
+// A file transfer between tg and local, with a progress message edited while bytes stream through
+type transfer struct {
+	Id        int
+	Action    string // "Saving" or "Sending"
+	Name      string
+	Total     int64 // 0 if unknown
+	n         atomic.Int64
+	ctx       context.Context
+	cancel    context.CancelFunc
+	startedAt time.Time
+	status    string // final status
+	done      chan struct{}
+	doneOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+var (
+	transfers      = map[int]*transfer{}
+	lastTransferId int
+	transfersLock  sync.Mutex
+)
+
+// Start a transfer and send it's progress message to chat.
+// Readers wrapped by the transfer fail once it's cancelled. Finish must be called
+func startTransfer(ctx context.Context, bot *tele.Bot, chat tele.Recipient, action string, name string,
+	total int64) *transfer {
+	transfersLock.Lock()
+	lastTransferId++
+	t := &transfer{
+		Id:        lastTransferId,
+		Action:    action,
+		Name:      name,
+		Total:     total,
+		startedAt: time.Now(),
+		done:      make(chan struct{}),
+	}
+	t.ctx, t.cancel = context.WithCancel(ctx)
+	transfers[t.Id] = t
+	transfersLock.Unlock()
+	msg, err := bot.Send(chat, t.text(""), t.menu(), tele.NoPreview)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(TRANSFER_REFRESH_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err == nil {
+					bot.Edit(msg, t.text(""), t.menu(), tele.NoPreview)
+				}
+			case <-t.done:
+				if err == nil {
+					bot.Edit(msg, t.text(t.status), &tele.ReplyMarkup{}, tele.NoPreview)
+				}
+				return
+			}
+		}
+	}()
+	return t
+}
+
+func getTransfer(id int) *transfer {
+	transfersLock.Lock()
+	defer transfersLock.Unlock()
+	return transfers[id]
+}
+
+func (t *transfer) Cancel() {
+	t.cancel()
+}
+
+// Context of the transfer, which is done when it's cancelled
+func (t *transfer) Context() context.Context {
+	return t.ctx
+}
+
+// Finish the transfer with the result err, editing the progress message to final status
+func (t *transfer) Finish(err error) {
+	t.doneOnce.Do(func() {
+		transfersLock.Lock()
+		delete(transfers, t.Id)
+		transfersLock.Unlock()
+		elapsed := time.Since(t.startedAt).Round(time.Second)
+		switch {
+		case err == nil:
+			t.status = fmt.Sprintf("✓ Done: %s in %s", util.BytesSize(float64(t.n.Load())), elapsed)
+		case t.ctx.Err() != nil:
+			t.status = "✗ Cancelled"
+		default:
+			t.status = fmt.Sprintf("✗ Failed: %v", err)
+		}
+		close(t.done)
+		t.cancel()
+	})
+	t.wg.Wait()
+}
+
+// Count bytes read from r as transferred. Reads fail once the transfer is cancelled
+func (t *transfer) Wrap(r io.Reader) io.Reader {
+	return &transferReader{r, t}
+}
+
+func (t *transfer) menu() *tele.ReplyMarkup {
+	return &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{
+		{{Text: "Cancel", Data: fmt.Sprintf("cancel_%d", t.Id)}},
+	}}
+}
+
+// Progress message. First line: "Transfer <id> - <action> <name>"
+func (t *transfer) text(status string) string {
+	n := t.n.Load()
+	elapsed := time.Since(t.startedAt)
+	rate := float64(n) / elapsed.Seconds()
+	text := fmt.Sprintf("Transfer %d - %s %s\n", t.Id, t.Action, t.Name)
+	if t.Total > 0 {
+		percent := min(float64(n)/float64(t.Total), 1)
+		filled := int(percent * TRANSFER_BAR_WIDTH)
+		text += fmt.Sprintf("[%s%s] %.1f%%\n", strings.Repeat("█", filled),
+			strings.Repeat("░", TRANSFER_BAR_WIDTH-filled), percent*100)
+		text += fmt.Sprintf("%s / %s", util.BytesSize(float64(n)), util.BytesSize(float64(t.Total)))
+	} else {
+		text += util.BytesSize(float64(n))
+	}
+	text += fmt.Sprintf(" · %s/s", util.BytesSize(rate))
+	if status != "" {
+		return text + "\n" + status
+	}
+	if t.Total > 0 && rate > 0 && n < t.Total {
+		eta := time.Duration(float64(t.Total-n)/rate) * time.Second
+		text += fmt.Sprintf(" · ETA %s", eta.Round(time.Second))
+	}
+	return text
+}
+
+type transferReader struct {
+	r io.Reader
+	t *transfer
+}
+
+func (tr *transferReader) Read(p []byte) (int, error) {
+	if err := tr.t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := tr.r.Read(p)
+	tr.t.n.Add(int64(n))
+	return n, err
+}
+
+// Send a local file as document, with a progress message. If the Bot API server is in --local mode,
+// it reads the file by itself and no progress is available
+func sendFileWithProgress(ctx context.Context, bot *tele.Bot, C tele.Context, filepath string) {
+	if config.ConfigData.TelegramApiLocal {
+		if err := C.Reply(&tele.Document{File: localFile(filepath), FileName: path.Base(filepath)}); err != nil {
+			C.Reply(fmt.Sprintf("Failed to send %s: %v", filepath, err))
+		}
+		return
+	}
+	f, err := os.Open(filepath)
+	if err != nil {
+		C.Reply(fmt.Sprintf("Failed to read %s: %v", filepath, err))
+		return
+	}
+	defer f.Close()
+	total := int64(0)
+	if stat, err := f.Stat(); err == nil {
+		total = stat.Size()
+	}
+	t := startTransfer(ctx, bot, C.Chat(), "Sending", filepath, total)
+	t.Finish(C.Reply(&tele.Document{File: tele.FromReader(t.Wrap(f)), FileName: path.Base(filepath)}))
+}
@@ -0,0 +1,171 @@
+package telegram
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/shlex"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/config"
+	"github.com/sagan/tgshell/util"
+	"github.com/sagan/tgshell/util/archive"
+)
+
+// First line prefix of the prompt asking what to do with an upload whose target file exists
+const UPLOAD_PROMPT = "Upload - "
+
+const UPLOAD_SKIPPED_SHOWN = 10 // max skipped entries of extraction shown
+
+// max total extracted size of an archive, as a multiple of the download limit
+const UPLOAD_EXTRACT_MAX_RATIO = 10
+
+const USAGE_UPLOAD = "Send a file to bot to save it to cwd. Caption of the file: [-x|--extract] [dir]\n" +
+	"-x: extract zip / tar / tar.gz file into dir"
+
+type uploadOptions struct {
+	Dir     string
+	Extract bool
+}
+
+// Parse caption of a document sent to bot: "[-x|--extract] [dir]". Relative dir is resolved against cwd
+func parseUploadCaption(caption string) (*uploadOptions, error) {
+	args, err := shlex.Split(caption)
+	if err != nil {
+		return nil, err
+	}
+	options := &uploadOptions{}
+	for _, arg := range args {
+		switch {
+		case arg == "-x" || arg == "--extract":
+			options.Extract = true
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown option %s", arg)
+		case options.Dir != "":
+			return nil, fmt.Errorf("only one dir is allowed")
+		default:
+			options.Dir = arg
+		}
+	}
+	options.Dir = absPath(options.Dir)
+	return options, nil
+}
+
+// Return error if dir is outside of all configured upload roots
+func checkUploadDir(dir string) error {
+	if len(config.ConfigData.UploadRoots) == 0 {
+		return nil
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	for _, root := range config.ConfigData.UploadRoots {
+		realRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(realRoot, realDir); err == nil &&
+			rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("'%s' is outside of upload roots: %s", dir, strings.Join(config.ConfigData.UploadRoots, ", "))
+}
+
+// Return a non-existing path like "name (1).ext" in the dir of filepath
+func uniquePath(filepath string) string {
+	ext := path.Ext(filepath)
+	base := strings.TrimSuffix(filepath, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// Prompt asking what to do with an upload whose target file exists. It replies to the document message
+func uploadExistsPrompt(filepath string, stat os.FileInfo) (string, *tele.ReplyMarkup) {
+	data := fmt.Sprintf("%s%s\nFile already exists (%s, modified %s)", UPLOAD_PROMPT, filepath,
+		util.BytesSize(float64(stat.Size())), stat.ModTime().Format("2006-01-02 15:04:05"))
+	return data, &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{
+		{{Text: "Overwrite", Data: "overwrite_x"}, {Text: "Keep both", Data: "keep_x"}, {Text: "Cancel", Data: "cancel_x"}},
+	}}
+}
+
+// Download a document sent to bot to dst, reporting progress. If extract is set, dst is a dir,
+// into which the document (an archive) is extracted
+func saveDocument(ctx context.Context, bot *tele.Bot, messenger chan<- *TgGlobalMsg, chatid int64,
+	tgdocument *tele.Document, dst string, extract bool) {
+	reply := func(data string) {
+		messenger <- &TgGlobalMsg{Type: TYPE_GLOBAL, Chatid: chatid, Data: data}
+	}
+	if limit := config.ConfigData.DownloadLimit(); tgdocument.FileSize > limit {
+		reply(fmt.Sprintf("File '%s' (%s) exceeds the Bot API download limit (%s)", tgdocument.FileName,
+			util.BytesSize(float64(tgdocument.FileSize)), util.BytesSize(float64(limit))))
+		return
+	}
+	format := ""
+	savePath := dst
+	if extract {
+		if format = archive.DetectFormat(tgdocument.FileName); format == "" {
+			reply(fmt.Sprintf("Can not extract '%s': not a zip, tar or tar.gz file", tgdocument.FileName))
+			return
+		}
+		tmpdir, err := os.MkdirTemp("", "tgshell-upload-")
+		if err != nil {
+			reply(fmt.Sprintf("Failed to create temp dir: %v", err))
+			return
+		}
+		defer os.RemoveAll(tmpdir)
+		savePath = path.Join(tmpdir, path.Base(tgdocument.FileName))
+	}
+	t := startTransfer(ctx, bot, tele.ChatID(chatid), "Saving", dst, tgdocument.FileSize)
+	hash := sha256.New()
+	err := func() error {
+		reader, err := util.OpenTgFile(t.Context(), config.ConfigData.ApiUrl(), config.ConfigData.TelegramToken,
+			tgdocument.FileID)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		return util.WriteFileAtomic(savePath, io.TeeReader(t.Wrap(reader), hash), 0644)
+	}()
+	t.Finish(err)
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if err != nil {
+		reply(fmt.Sprintf("Failed to save file to '%s': %v", dst, err))
+	} else if extract {
+		result, err := archive.Extract(ctx, savePath, format, dst,
+			config.ConfigData.DownloadLimit()*UPLOAD_EXTRACT_MAX_RATIO)
+		if err != nil {
+			reply(fmt.Sprintf("Failed to extract '%s' to '%s': %v", tgdocument.FileName, dst, err))
+			return
+		}
+		data := fmt.Sprintf("Extracted %d files of '%s' (SHA-256: %s) to the below dir:", result.Extracted,
+			tgdocument.FileName, sum)
+		if len(result.Skipped) > 0 {
+			skipped := result.Skipped[:min(len(result.Skipped), UPLOAD_SKIPPED_SHOWN)]
+			data += fmt.Sprintf("\n%d entries skipped (existing files or links): %s", len(result.Skipped),
+				strings.Join(skipped, ", "))
+			if len(result.Skipped) > len(skipped) {
+				data += ", ..."
+			}
+		}
+		reply(data)
+		reply(dst)
+	} else if result, ok := handleSplitUpload(path.Dir(dst), path.Base(dst)); ok {
+		reply(result)
+	} else {
+		reply(fmt.Sprintf("Successfully saved file (SHA-256: %s) to the below path:", sum))
+		reply(dst)
+	}
+}
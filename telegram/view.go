@@ -0,0 +1,307 @@
+package telegram
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/shlex"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/constants"
+	"github.com/sagan/tgshell/util"
+	"github.com/sagan/tgshell/util/redact"
+)
+
+const VIEW_PAGE_LINES = 40                            // max lines of a page
+const VIEW_PAGE_CHARS = constants.TG_TEXT_LIMIT - 600 // max chars of a page, leaving room for header
+const VIEW_LINE_WIDTH = 200                           // longer lines are truncated
+const VIEW_MAX_SIZE = 20 * 1024 * 1024                // larger files are refused
+const VIEW_BINARY_PROBE = 8000                        // detect binary file by it's leading bytes
+const VIEW_REDACT_CONTEXT = 200                       // lines before page also redacted, to find private key blocks
+
+// First line prefix of the ForceReply prompt asking for search pattern in a viewed file
+const PROMPT_VIEW_SEARCH = "Search - "
+
+const USAGE_VIEW = "Usage: /view [-g regexp] <file>[:line]\n" +
+	"View a text file in pages. -g: jump to the first line matching regexp"
+
+// Telegram code block language of file extensions, used for syntax highlighting
+var viewLanguages = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".ts": "typescript", ".sh": "bash", ".bash": "bash",
+	".yaml": "yaml", ".yml": "yaml", ".json": "json", ".toml": "toml", ".md": "markdown", ".c": "c", ".h": "c",
+	".cpp": "cpp", ".rs": "rust", ".java": "java", ".rb": "ruby", ".php": "php", ".html": "html", ".css": "css",
+	".sql": "sql", ".xml": "xml", ".ini": "ini", ".lua": "lua", ".kt": "kotlin", ".swift": "swift",
+}
+
+// A page of a text file. Rendered as message:
+//
+//	View - <path>
+//	Lines <from>-<to> of <total>
+//	Search: "<pattern>" (line <n>)   (optional)
+//	<pre> block of numbered lines
+type fileView struct {
+	Path      string
+	Line      int // first line of page, 1-based
+	Search    string
+	Highlight int // line of the current search match. 0 if none
+}
+
+// Parse "/view" arg "file[:line]". The ":line" suffix is only recognized if file itself does not exist
+func parseViewArg(arg string) (filepath string, line int) {
+	filepath = absPath(arg)
+	if i := strings.LastIndex(arg, ":"); i > 0 {
+		if n, err := strconv.Atoi(arg[i+1:]); err == nil && n > 0 {
+			if _, err := os.Stat(filepath); err != nil {
+				return absPath(arg[:i]), n
+			}
+		}
+	}
+	return filepath, 1
+}
+
+// Parse "/view [-g regexp] <file>[:line]" payload
+func parseViewPayload(payload string) (*fileView, error) {
+	args, err := shlex.Split(payload)
+	if err != nil {
+		return nil, err
+	}
+	view := &fileView{}
+	if len(args) == 3 && args[0] == "-g" {
+		view.Search = args[1]
+		args = args[2:]
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("invalid args")
+	}
+	view.Path, view.Line = parseViewArg(args[0])
+	return view, nil
+}
+
+// Parse the state of a view from it's message text
+func parseFileView(text string) *fileView {
+	lines := strings.Split(text, "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "View - ") {
+		return nil
+	}
+	view := &fileView{Path: strings.TrimPrefix(lines[0], "View - "), Line: 1}
+	fmt.Sscanf(lines[1], "Lines %d-", &view.Line)
+	if len(lines) > 2 && strings.HasPrefix(lines[2], "Search: ") {
+		rest := strings.TrimPrefix(lines[2], "Search: ")
+		if quoted, err := strconv.QuotedPrefix(rest); err == nil {
+			view.Search, _ = strconv.Unquote(quoted)
+			fmt.Sscanf(strings.TrimPrefix(rest, quoted), " (line %d)", &view.Highlight)
+		}
+	}
+	return view
+}
+
+// Read all lines of a text file. Refuse binary or too large files
+func readTextLines(filepath string) ([]string, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("not a regular file")
+	}
+	if stat.Size() > VIEW_MAX_SIZE {
+		return nil, fmt.Errorf("file is too large (%s). Max %s", util.BytesSize(float64(stat.Size())),
+			util.BytesSize(VIEW_MAX_SIZE))
+	}
+	reader := bufio.NewReader(f)
+	probe, _ := reader.Peek(VIEW_BINARY_PROBE)
+	if isBinary(probe) {
+		return nil, fmt.Errorf("binary file. To download it, use /getfile")
+	}
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, VIEW_MAX_SIZE)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+	return lines, scanner.Err()
+}
+
+// Whether data looks like binary: contains NUL or is not valid UTF-8
+func isBinary(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+	// a rune may be truncated at the end of probe
+	for i := 0; i < utf8.UTFMax-1 && len(data) > 0; i++ {
+		if utf8.Valid(data) {
+			return false
+		}
+		data = data[:len(data)-1]
+	}
+	return !utf8.Valid(data)
+}
+
+func displayLine(line string) string {
+	line = strings.ReplaceAll(line, "\t", "    ")
+	if utf8.RuneCountInString(line) > VIEW_LINE_WIDTH {
+		line = string([]rune(line)[:VIEW_LINE_WIDTH]) + "…"
+	}
+	return line
+}
+
+// Return the end (exclusive, 0-based) of the page starting at 0-based start
+func pageEnd(lines []string, start int) int {
+	chars := 0
+	end := start
+	for end < len(lines) && end-start < VIEW_PAGE_LINES {
+		chars += len(displayLine(lines[end])) + 10
+		if chars > VIEW_PAGE_CHARS && end > start {
+			break
+		}
+		end++
+	}
+	return end
+}
+
+// Return the start (0-based) of the page ending at 0-based end (exclusive)
+func pageStartBefore(lines []string, end int) int {
+	chars := 0
+	start := end
+	for start > 0 && end-start < VIEW_PAGE_LINES {
+		chars += len(displayLine(lines[start-1])) + 10
+		if chars > VIEW_PAGE_CHARS && start < end {
+			break
+		}
+		start--
+	}
+	return start
+}
+
+// Find the search pattern from 1-based line from, forward or backward.
+// On match, set Highlight and move the page to it. Return whether found
+func (v *fileView) find(lines []string, from int, backward bool) bool {
+	re, err := regexp.Compile(v.Search)
+	if err != nil {
+		re = regexp.MustCompile(regexp.QuoteMeta(v.Search))
+	}
+	step := 1
+	if backward {
+		step = -1
+	}
+	for i := from - 1; i >= 0 && i < len(lines); i += step {
+		if re.MatchString(lines[i]) {
+			v.Highlight = i + 1
+			v.Line = max(i+1-VIEW_PAGE_LINES/4, 1) // show some context before match
+			if pageEnd(lines, v.Line-1) <= i {
+				v.Line = i + 1
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Move page by action: "top", "prev", "next", "end"
+func (v *fileView) move(lines []string, action string) {
+	start := min(max(v.Line-1, 0), max(len(lines)-1, 0))
+	switch action {
+	case "top":
+		start = 0
+	case "prev":
+		start = pageStartBefore(lines, start)
+	case "next":
+		if end := pageEnd(lines, start); end < len(lines) {
+			start = end
+		}
+	case "end":
+		start = pageStartBefore(lines, len(lines))
+	}
+	v.Line = start + 1
+}
+
+// Render page as a HTML message. redactor is nil if content is not redacted
+func (v *fileView) render(lines []string, redactor *redact.Redactor) (string, *tele.ReplyMarkup) {
+	start := min(max(v.Line-1, 0), max(len(lines)-1, 0))
+	end := pageEnd(lines, start)
+	v.Line = start + 1
+	page := lines[start:end]
+	if redactor != nil {
+		from := max(start-VIEW_REDACT_CONTEXT, 0)
+		page = redactor.RedactLines(lines[from:end])[start-from:]
+	}
+	data := html.EscapeString(fmt.Sprintf("View - %s\nLines %d-%d of %d\n", v.Path, start+1, end, len(lines)))
+	if v.Search != "" {
+		search := "Search: " + strconv.Quote(v.Search)
+		if v.Highlight > 0 {
+			search += fmt.Sprintf(" (line %d)", v.Highlight)
+		}
+		data += html.EscapeString(search) + "\n"
+	}
+	width := len(strconv.Itoa(len(lines)))
+	code := ""
+	for i := start; i < end; i++ {
+		sep := "│"
+		if i+1 == v.Highlight {
+			sep = "▶"
+		}
+		code += fmt.Sprintf("%*d %s %s\n", width, i+1, sep, displayLine(page[i-start]))
+	}
+	if len(lines) == 0 {
+		code = "(empty file)\n"
+	}
+	if lang := viewLanguages[strings.ToLower(path.Ext(v.Path))]; lang != "" {
+		data += fmt.Sprintf(`<pre><code class="language-%s">%s</code></pre>`, lang, html.EscapeString(code))
+	} else {
+		data += "<pre>" + html.EscapeString(code) + "</pre>"
+	}
+	menu := &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{
+		{{Text: "⤒ Top", Data: "top_x"}, {Text: "◀ Prev", Data: "prev_x"},
+			{Text: "Next ▶", Data: "next_x"}, {Text: "End ⤓", Data: "end_x"}},
+		{{Text: "🔍 Search", Data: "search_x"}},
+	}}
+	if v.Search != "" {
+		menu.InlineKeyboard[1] = append(menu.InlineKeyboard[1],
+			tele.InlineButton{Text: "◀ Find", Data: "findprev_x"}, tele.InlineButton{Text: "Find ▶", Data: "findnext_x"})
+	}
+	return data, menu
+}
+
+// Text of the ForceReply prompt asking for search pattern in the viewed file, from line
+func viewSearchPrompt(v *fileView) string {
+	return fmt.Sprintf("%s%s\nFrom line %d. Reply with a regexp to search", PROMPT_VIEW_SEARCH, v.Path, v.Line)
+}
+
+// Handle user's reply to the search prompt. Return the view message to send
+func handleViewSearchPrompt(prompt string, input string, redactor *redact.Redactor) (string, *tele.ReplyMarkup,
+	error) {
+	lines := strings.Split(prompt, "\n")
+	v := &fileView{Path: strings.TrimPrefix(lines[0], PROMPT_VIEW_SEARCH), Line: 1, Search: input}
+	if len(lines) > 1 {
+		fmt.Sscanf(lines[1], "From line %d.", &v.Line)
+	}
+	return v.open(v.Line, redactor)
+}
+
+// Read the file and render the page. If searching, jump to the first match from line
+func (v *fileView) open(from int, redactor *redact.Redactor) (string, *tele.ReplyMarkup, error) {
+	lines, err := readTextLines(v.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	if v.Search != "" {
+		if !v.find(lines, from, false) {
+			return "", nil, fmt.Errorf("pattern %q not found from line %d", v.Search, from)
+		}
+	}
+	data, menu := v.render(lines, redactor)
+	return data, menu, nil
+}
@@ -0,0 +1,170 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/sagan/tgshell/constants"
+	"github.com/sagan/tgshell/executor"
+	"github.com/sagan/tgshell/util"
+)
+
+const WATCH_MIN_INTERVAL = 2 * time.Second // avoid hitting tg message edit rate limit
+const WATCH_MAX_DURATION = time.Hour       // watch stops automatically after it
+const WATCH_OUTPUT_LIMIT = constants.TG_TEXT_LIMIT - 500
+
+// Run a cmdline periodically and edit a single message with the latest output
+type watch struct {
+	Id        int
+	Cmdline   string
+	Interval  time.Duration
+	startedAt time.Time
+	paused    atomic.Bool
+	wake      chan struct{} // re-render the message
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+var (
+	watches     = map[int]*watch{}
+	lastWatchId int
+	watchesLock sync.Mutex
+)
+
+// Parse watch interval. Either seconds ("5") or a Go duration ("1m30s")
+func parseWatchInterval(str string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(str); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(str)
+}
+
+// Start watching cmdline in session, editing msg with the output
+func startWatch(ctx context.Context, bot *tele.Bot, msg *tele.Message, session *TgExecutorSession,
+	interval time.Duration, cmdline string) *watch {
+	watchesLock.Lock()
+	lastWatchId++
+	w := &watch{
+		Id:        lastWatchId,
+		Cmdline:   cmdline,
+		Interval:  interval,
+		startedAt: time.Now(),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+	watches[w.Id] = w
+	watchesLock.Unlock()
+	go w.run(ctx, bot, msg, session)
+	return w
+}
+
+func getWatch(id int) *watch {
+	watchesLock.Lock()
+	defer watchesLock.Unlock()
+	return watches[id]
+}
+
+func (w *watch) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+func (w *watch) SetPaused(paused bool) {
+	w.paused.Store(paused)
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watch) run(ctx context.Context, bot *tele.Bot, msg *tele.Message, session *TgExecutorSession) {
+	defer func() {
+		watchesLock.Lock()
+		delete(watches, w.Id)
+		watchesLock.Unlock()
+	}()
+	ctx, cancel := util.ContextWithCancelSign(ctx, w.stop)
+	defer cancel()
+	output := ""
+	var updatedAt time.Time
+	render := func(status string) {
+		menu := &tele.ReplyMarkup{}
+		if status == "" {
+			action := tele.InlineButton{Text: "Pause", Data: fmt.Sprintf("pause_%d", w.Id)}
+			if w.paused.Load() {
+				action = tele.InlineButton{Text: "Resume", Data: fmt.Sprintf("resume_%d", w.Id)}
+			}
+			menu.InlineKeyboard = [][]tele.InlineButton{{action, {Text: "Stop", Data: fmt.Sprintf("stop_%d", w.Id)}}}
+		}
+		bot.Edit(msg, w.text(output, updatedAt, status), menu, tele.NoPreview)
+	}
+	refresh := func() {
+		output = w.exec(ctx, session)
+		updatedAt = time.Now()
+		render("")
+	}
+	refresh()
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(WATCH_MAX_DURATION)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !w.paused.Load() {
+				refresh()
+			}
+		case <-w.wake:
+			render("")
+		case <-w.stop:
+			render("stopped")
+			return
+		case <-deadline.C:
+			render(fmt.Sprintf("stopped after max duration %s", WATCH_MAX_DURATION))
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Run cmdline once, return it's (redacted) output, truncated to the last WATCH_OUTPUT_LIMIT chars
+func (w *watch) exec(ctx context.Context, session *TgExecutorSession) string {
+	ctx = executor.WithExecOptions(ctx, &executor.ExecOptions{NoHistory: true, Env: session.Environ()})
+	cmdOut := session.Executor.Exec(ctx, w.Cmdline, false)
+	if cmdOut == nil {
+		return ""
+	}
+	output := ""
+	for data := range cmdOut {
+		output += data
+	}
+	if session.Redacting() {
+		output = session.newRedactor().RedactText(output)
+	}
+	if runes := []rune(output); len(runes) > WATCH_OUTPUT_LIMIT {
+		output = "..." + string(runes[len(runes)-WATCH_OUTPUT_LIMIT:])
+	}
+	return output
+}
+
+// Message text. First line: "Watch <id> - every <interval>: <cmdline>"
+func (w *watch) text(output string, updatedAt time.Time, status string) string {
+	if status == "" && w.paused.Load() {
+		status = "paused"
+	}
+	header := fmt.Sprintf("Watch %d - every %s: %s\n%s", w.Id, w.Interval, w.Cmdline,
+		updatedAt.Format("2006-01-02 15:04:05"))
+	if status != "" {
+		header += " (" + status + ")"
+	}
+	return header + "\n\n" + strings.TrimRight(output, "\n")
+}
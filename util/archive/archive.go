@@ -0,0 +1,225 @@
+// Create zip or tar.gz archives of a dir, streaming to a writer
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+const FORMAT_ZIP = "zip"
+const FORMAT_TARGZ = "tar.gz"
+
+type Options struct {
+	Format  string   // FORMAT_ZIP or FORMAT_TARGZ
+	Include []string // globs. If not empty, only files matching any of them are included
+	Exclude []string // globs. Files and dirs matching any of them are skipped
+}
+
+// Return whether a glob matches the slash separated relative path, or it's base name
+func matchAny(globs []string, rel string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, rel); matched {
+			return true
+		}
+		if matched, _ := path.Match(glob, path.Base(rel)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+type entryWriter interface {
+	writeDir(rel string, info fs.FileInfo) error
+	writeFile(rel string, info fs.FileInfo, file string) error
+	writeSymlink(rel string, info fs.FileInfo, target string) error
+	Close() error
+}
+
+// Write archive of dir to w. Paths in archive are prefixed with base name of dir
+func Write(ctx context.Context, w io.Writer, dir string, options *Options) error {
+	var ew entryWriter
+	switch options.Format {
+	case FORMAT_ZIP:
+		ew = &zipWriter{zip.NewWriter(w)}
+	case FORMAT_TARGZ:
+		gw := gzip.NewWriter(w)
+		ew = &tarWriter{tar.NewWriter(gw), gw}
+	default:
+		return fmt.Errorf("unsupported archive format '%s'", options.Format)
+	}
+	root := filepath.Base(filepath.Clean(dir))
+	if realDir, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = realDir // follow symlink to dir
+	}
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && matchAny(options.Exclude, rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		name := path.Join(root, rel)
+		switch {
+		case entry.IsDir():
+			if len(options.Include) > 0 {
+				return nil // only create dirs of included files
+			}
+			return ew.writeDir(name, info)
+		case len(options.Include) > 0 && !matchAny(options.Include, rel):
+			return nil
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			return ew.writeSymlink(name, info, target)
+		case info.Mode().IsRegular():
+			return ew.writeFile(name, info, file)
+		}
+		return nil // skip special files
+	})
+	if err != nil {
+		ew.Close()
+		return err
+	}
+	return ew.Close()
+}
+
+func copyFileTo(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (zw *zipWriter) header(name string, info fs.FileInfo) (*zip.FileHeader, error) {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	return header, nil
+}
+
+func (zw *zipWriter) writeDir(name string, info fs.FileInfo) error {
+	header, err := zw.header(name+"/", info)
+	if err != nil {
+		return err
+	}
+	header.Method = zip.Store
+	_, err = zw.CreateHeader(header)
+	return err
+}
+
+func (zw *zipWriter) writeFile(name string, info fs.FileInfo, file string) error {
+	header, err := zw.header(name, info)
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	return copyFileTo(w, file)
+}
+
+func (zw *zipWriter) writeSymlink(name string, info fs.FileInfo, target string) error {
+	header, err := zw.header(name, info)
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+type tarWriter struct {
+	*tar.Writer
+	gw *gzip.Writer
+}
+
+func (tw *tarWriter) write(name string, info fs.FileInfo, link string) error {
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	return tw.WriteHeader(header)
+}
+
+func (tw *tarWriter) writeDir(name string, info fs.FileInfo) error {
+	return tw.write(name, info, "")
+}
+
+// The header size is from walk time. A file which grows meanwhile is truncated to it,
+// one which shrinks is padded with zeros, so the archive stays valid
+func (tw *tarWriter) writeFile(name string, info fs.FileInfo, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := tw.write(name, info, ""); err != nil {
+		return err
+	}
+	n, err := io.CopyN(tw, f, info.Size())
+	if err == io.EOF {
+		_, err = io.CopyN(tw, zeroReader{}, info.Size()-n)
+	}
+	return err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func (tw *tarWriter) writeSymlink(name string, info fs.FileInfo, target string) error {
+	return tw.write(name, info, target)
+}
+
+func (tw *tarWriter) Close() error {
+	if err := tw.Writer.Close(); err != nil {
+		return err
+	}
+	return tw.gw.Close()
+}
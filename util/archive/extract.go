@@ -0,0 +1,180 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Return archive format of filename by it's extension: FORMAT_ZIP, FORMAT_TARGZ, "tar", or "" if not archive
+func DetectFormat(filename string) string {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return FORMAT_ZIP
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FORMAT_TARGZ
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// Result of extracting an archive
+type ExtractResult struct {
+	Extracted int      // number of extracted files
+	Skipped   []string // entries not extracted: existing files, links and special files
+	Size      int64    // total bytes of extracted files
+	root      string   // target dir with symlinks resolved
+	maxSize   int64
+}
+
+// Extract a zip, tar or tar.gz file of format into dir. Existing files are never overwritten but skipped.
+// Symlinks and hard links are skipped. Entries which would be extracted outside of dir
+// (lexically, or through an existing symlink inside dir) are refused.
+// Extraction fails if total size of extracted files would exceed maxSize (0 means no limit)
+func Extract(ctx context.Context, file string, format string, dir string, maxSize int64) (*ExtractResult, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := &ExtractResult{root: root, maxSize: maxSize}
+	switch format {
+	case FORMAT_ZIP:
+		zr, err := zip.OpenReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			err := result.extract(dir, f.Name, f.Mode(), func() (io.ReadCloser, error) { return f.Open() })
+			if err != nil {
+				return result, err
+			}
+		}
+		return result, nil
+	case FORMAT_TARGZ, "tar":
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if format == FORMAT_TARGZ {
+			gr, err := gzip.NewReader(f)
+			if err != nil {
+				return nil, err
+			}
+			defer gr.Close()
+			r = gr
+		}
+		tr := tar.NewReader(r)
+		for {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			header, err := tr.Next()
+			if err == io.EOF {
+				return result, nil
+			} else if err != nil {
+				return result, err
+			}
+			err = result.extract(dir, header.Name, header.FileInfo().Mode(),
+				func() (io.ReadCloser, error) { return io.NopCloser(tr), nil })
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported archive format '%s'", format)
+}
+
+func (result *ExtractResult) extract(dir string, name string, mode fs.FileMode,
+	open func() (io.ReadCloser, error)) error {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if !isInside(dir, target) || filepath.IsAbs(name) {
+		return fmt.Errorf("illegal entry '%s' outside of target dir", name)
+	}
+	// an existing parent dir may be a symlink pointing outside
+	if !isInside(result.root, resolveExisting(target)) {
+		return fmt.Errorf("illegal entry '%s' outside of target dir (through symlink)", name)
+	}
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(target, 0755)
+	case !mode.IsRegular():
+		result.Skipped = append(result.Skipped, name)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode.Perm()|0600)
+	if os.IsExist(err) {
+		result.Skipped = append(result.Skipped, name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	in, err := open()
+	if err != nil {
+		out.Close()
+		return err
+	}
+	var reader io.Reader = in
+	if result.maxSize > 0 {
+		reader = io.LimitReader(in, result.maxSize-result.Size+1)
+	}
+	n, err := io.Copy(out, reader)
+	in.Close()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	result.Size += n
+	if err == nil && result.maxSize > 0 && result.Size > result.maxSize {
+		os.Remove(target)
+		return fmt.Errorf("total extracted size exceeds limit %d bytes", result.maxSize)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract '%s': %w", name, err)
+	}
+	result.Extracted++
+	return nil
+}
+
+// Return whether target is dir or inside dir, lexically
+func isInside(dir string, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Return the nearest existing ancestor (or itself) of target, with symlinks resolved
+func resolveExisting(target string) string {
+	for {
+		if _, err := os.Lstat(target); err == nil {
+			if resolved, err := filepath.EvalSymlinks(target); err == nil {
+				return resolved
+			}
+			// dangling symlink
+			return target
+		}
+		parent := filepath.Dir(target)
+		if parent == target {
+			return target
+		}
+		target = parent
+	}
+}
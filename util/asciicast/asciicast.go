@@ -0,0 +1,81 @@
+// Write terminal session recordings in asciicast v2 format.
+// See https://docs.asciinema.org/manual/asciicast/v2/
+package asciicast
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type header struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Title     string `json:"title,omitempty"`
+}
+
+// A recorder appends timestamped input and output events to a .cast file. It's safe for concurrent use.
+type Recorder struct {
+	Path  string
+	file  *os.File
+	start time.Time
+	mu    sync.Mutex
+}
+
+// Create a .cast file at filepath and write the header
+func NewRecorder(filepath string, width int, height int, title string) (*Recorder, error) {
+	file, err := os.OpenFile(filepath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{Path: filepath, file: file, start: time.Now()}
+	data, _ := json.Marshal(&header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+	})
+	if _, err := fmt.Fprintf(file, "%s\n", data); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) event(code string, data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	event, _ := json.Marshal([]any{time.Since(r.start).Seconds(), code, data})
+	fmt.Fprintf(r.file, "%s\n", event)
+}
+
+// Record data written to terminal
+func (r *Recorder) Input(data string) {
+	r.event("i", data)
+}
+
+// Record data printed by terminal. Bare "\n" are converted to "\r\n" so it replays correctly.
+func (r *Recorder) Output(data string) {
+	data = strings.ReplaceAll(strings.ReplaceAll(data, "\r\n", "\n"), "\n", "\r\n")
+	r.event("o", data)
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,113 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A line of diff result. Op is ' ' (unchanged), '-' (deleted from a) or '+' (added in b)
+type DiffLine struct {
+	Op   byte
+	Text string
+}
+
+// Diff two lists of lines using longest common subsequence. O(len(a)*len(b)), only for small inputs
+func Diff(a []string, b []string) []DiffLine {
+	// lcs[i][j]: length of LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var result []DiffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, DiffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, DiffLine{'-', a[i]})
+			i++
+		default:
+			result = append(result, DiffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		result = append(result, DiffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		result = append(result, DiffLine{'+', b[j]})
+	}
+	return result
+}
+
+// Return unified diff of a and b, with context lines around changes.
+// Line numbers in hunk headers start from startLine (1-based). Return "" if a and b are equal
+func UnifiedDiff(nameA string, nameB string, a []string, b []string, startLine int, context int) string {
+	lines := Diff(a, b)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+	hasChange := false
+	// line numbers (0-based offsets) in a and b of each diff line
+	offsetsA := make([]int, len(lines)+1)
+	offsetsB := make([]int, len(lines)+1)
+	for k, line := range lines {
+		offsetsA[k+1], offsetsB[k+1] = offsetsA[k], offsetsB[k]
+		if line.Op != '+' {
+			offsetsA[k+1]++
+		}
+		if line.Op != '-' {
+			offsetsB[k+1]++
+		}
+	}
+	for k := 0; k < len(lines); {
+		if lines[k].Op == ' ' {
+			k++
+			continue
+		}
+		hasChange = true
+		// extend hunk while changes are within 2*context lines of each other
+		start := max(k-context, 0)
+		end := k
+		for end < len(lines) {
+			if lines[end].Op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].Op == ' ' {
+				next++
+			}
+			if next < len(lines) && next-end <= 2*context {
+				end = next
+			} else {
+				end = min(end+context, len(lines))
+				break
+			}
+		}
+		countA := offsetsA[end] - offsetsA[start]
+		countB := offsetsB[end] - offsetsB[start]
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", startLine+offsetsA[start], countA, startLine+offsetsB[start], countB)
+		for _, line := range lines[start:end] {
+			sb.WriteByte(line.Op)
+			sb.WriteString(line.Text)
+			sb.WriteByte('\n')
+		}
+		k = end
+	}
+	if !hasChange {
+		return ""
+	}
+	return sb.String()
+}
@@ -0,0 +1,152 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// If dst is an existing dir, return the path of src's base name inside it. Otherwise return dst
+func resolveDst(src string, dst string) string {
+	if stat, err := os.Stat(dst); err == nil && stat.IsDir() {
+		return filepath.Join(dst, filepath.Base(src))
+	}
+	return dst
+}
+
+// Copy a file or a dir recursively to dst. If dst is an existing dir, copy into it. Return the final path
+func CopyPath(src string, dst string) (string, error) {
+	dst = resolveDst(src, dst)
+	if _, err := os.Lstat(dst); err == nil {
+		return "", fmt.Errorf("%s already exists", dst)
+	}
+	if stat, err := os.Lstat(src); err == nil && stat.IsDir() && isInside(dst, src) {
+		return "", fmt.Errorf("can not copy %s into itself", src)
+	}
+	err := filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil // skip special files
+	})
+	return dst, err
+}
+
+// Whether the non-existing path dst is dir itself or inside it, after resolving symlinks
+func isInside(dst string, dir string) bool {
+	realDir := realPath(dir)
+	// dst does not exist yet, resolve the nearest existing ancestor
+	realDst, rest := filepath.Clean(dst), ""
+	for {
+		if _, err := os.Lstat(realDst); err == nil {
+			realDst = filepath.Join(realPath(realDst), rest)
+			break
+		}
+		parent := filepath.Dir(realDst)
+		if parent == realDst {
+			realDst = filepath.Join(realDst, rest)
+			break
+		}
+		rest = filepath.Join(filepath.Base(realDst), rest)
+		realDst = parent
+	}
+	rel, err := filepath.Rel(realDir, realDst)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Return the absolute path with symlinks resolved, or just the absolute path if it can not be resolved
+func realPath(path string) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return path
+}
+
+func copyFile(src string, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Move a file or dir to dst. If dst is an existing dir, move into it. Return the final path.
+// Fallback to copy & delete when moving across file systems
+func MovePath(src string, dst string) (string, error) {
+	dst = resolveDst(src, dst)
+	if _, err := os.Lstat(dst); err == nil {
+		return "", fmt.Errorf("%s already exists", dst)
+	}
+	err := os.Rename(src, dst)
+	if err == nil {
+		return dst, nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return "", err
+	}
+	if _, err := CopyPath(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return "", err
+	}
+	return dst, os.RemoveAll(src)
+}
+
+// Write all content of r to a temp file in the same dir of dst, then rename it to dst,
+// so that a failed or cancelled write never leaves a truncated dst
+func WriteFileAtomic(dst string, r io.Reader, perm fs.FileMode) error {
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name()) // no-op after rename
+	if err = out.Chmod(perm); err != nil {
+		out.Close()
+		return err
+	}
+	if _, err = io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
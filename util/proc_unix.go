@@ -0,0 +1,48 @@
+//go:build !windows
+
+package util
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/sagan/tgshell/constants"
+)
+
+// Run cmd in a new process group, so that all it's children can be signaled together
+func SetProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// Send SIGINT, then SIGTERM, then SIGKILL to the process group of pid,
+// until exited is closed. Wait SIGNAL_GRACE_PERIOD between signals.
+func TerminateProcessGroup(pid int, exited <-chan struct{}) {
+	for _, signal := range []syscall.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL} {
+		if err := syscall.Kill(-pid, signal); err != nil {
+			return
+		}
+		select {
+		case <-exited:
+			return
+		case <-time.After(constants.SIGNAL_GRACE_PERIOD * time.Second):
+		}
+	}
+}
+
+// Return max resident set size of an exited process in bytes, 0 if unknown
+func MaxRSS(state *os.ProcessState) int64 {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		return int64(rusage.Maxrss) // darwin reports bytes
+	}
+	return int64(rusage.Maxrss) * 1024
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package util
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Process groups are not supported on Windows
+func SetProcessGroup(cmd *exec.Cmd) {
+}
+
+// Windows does not support signals. Kill the process directly
+func TerminateProcessGroup(pid int, exited <-chan struct{}) {
+	if process, err := os.FindProcess(pid); err == nil {
+		process.Kill()
+	}
+}
+
+// Memory usage is not available from process state on Windows
+func MaxRSS(state *os.ProcessState) int64 {
+	return 0
+}
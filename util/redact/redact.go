@@ -0,0 +1,234 @@
+// Mask secrets and common credentials in text output before it leaves the server
+package redact
+
+import (
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const MASK = "******"
+
+type rule struct {
+	re   *regexp.Regexp
+	repl string // replacement template
+}
+
+// Common credential formats
+var builtinRules = []*rule{
+	{regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`), MASK}, // AWS access key id
+	{regexp.MustCompile(`(?i)(aws_secret_access_key|aws_session_token)(\s*[=:]\s*)["']?[A-Za-z0-9/+=]{16,}`),
+		"${1}${2}" + MASK}, // AWS secret, keep the key name
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]+`), MASK}, // JWT
+	{regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`), MASK},                               // GitHub token
+	{regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`), MASK},                             // Slack token
+	{regexp.MustCompile(`\b[0-9]{8,10}:AA[A-Za-z0-9_-]{33}\b`), MASK},                          // Telegram bot token
+}
+
+var privateKeyBegin = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+var privateKeyEnd = regexp.MustCompile(`-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+
+// Data of an unterminated private key block beyond it is no longer suppressed
+const PRIVATE_KEY_MAX = 16 * 1024
+
+// Max size of the trailing partial line held back for the next chunk
+const HOLD_MAX = 1024
+
+// Held back data is flushed when the stream is idle for it
+const FLUSH_DELAY = 500 * time.Millisecond
+
+// Redactor of a output stream. Private key blocks, secrets and credentials may span multiple chunks
+// of the stream, so the trailing partial line of a chunk is held back until the next chunk, Flush or
+// stream end. A Redactor should not be shared between streams.
+type Redactor struct {
+	rules           []*rule
+	secrets         func() []string
+	pending         string // held back data, not redacted yet
+	inPrivateKey    bool
+	privateKeyBytes int // bytes suppressed of current private key block
+	mu              sync.Mutex
+}
+
+// Create a Redactor which masks the user-defined regexp patterns, the secret values,
+// and builtin credential formats. secrets is called for every chunk, so changes of secrets
+// take effect immediately; it may be nil. Invalid patterns are ignored
+func New(patterns []string, secrets func() []string) *Redactor {
+	r := &Redactor{rules: slices.Clone(builtinRules), secrets: secrets}
+	for _, pattern := range patterns {
+		if re, err := regexp.Compile(pattern); err != nil {
+			log.Printf("ignore invalid redact pattern %s: %v", pattern, err)
+		} else {
+			r.rules = append(r.rules, &rule{re, MASK})
+		}
+	}
+	return r
+}
+
+// Return non-empty secrets, longer first, in case one contains another
+func (r *Redactor) currentSecrets() (secrets []string) {
+	if r.secrets == nil {
+		return nil
+	}
+	for _, secret := range r.secrets() {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	slices.SortFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+	return secrets
+}
+
+// Return the chunk with all sensitive data masked. The trailing partial line of chunk may be
+// held back and returned by a later call, so the result may be empty
+func (r *Redactor) Redact(chunk string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.redact(chunk, false)
+}
+
+// Return the held back data, redacted
+func (r *Redactor) Flush() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.redact("", true)
+}
+
+// Redact a complete text which is not part of a stream
+func (r *Redactor) RedactText(text string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.redact(text, true)
+}
+
+// Redact lines of a text, keeping the count and order of lines, e.g. for showing them with line numbers.
+// Lines inside a private key block are masked individually
+func (r *Redactor) RedactLines(lines []string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		wasInPrivateKey := r.inPrivateKey
+		redacted := strings.TrimSuffix(r.redact(line+"\n", true), "\n")
+		if wasInPrivateKey && r.inPrivateKey {
+			redacted = MASK
+		}
+		result[i] = strings.ReplaceAll(redacted, "\n", " ")
+	}
+	return result
+}
+
+func (r *Redactor) redact(chunk string, flush bool) string {
+	text := r.pending + chunk
+	secrets := r.currentSecrets()
+	hold := len(text)
+	if !flush {
+		hold = holdIndex(text, secrets)
+	}
+	text, r.pending = text[:hold], text[hold:]
+	text = r.redactPrivateKeys(text)
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, MASK)
+	}
+	for _, rule := range r.rules {
+		text = rule.re.ReplaceAllString(text, rule.repl)
+	}
+	return text
+}
+
+// Return the index of text from which data may be an incomplete private key marker, secret or
+// credential, to be held back: the start of the trailing partial line (at most HOLD_MAX bytes),
+// or earlier if the tail is a prefix of a secret
+func holdIndex(text string, secrets []string) int {
+	hold := strings.LastIndex(text, "\n") + 1
+	if len(text)-hold > HOLD_MAX {
+		hold = len(text) - HOLD_MAX
+		for hold < len(text) && !utf8.RuneStart(text[hold]) {
+			hold++
+		}
+	}
+	for _, secret := range secrets {
+		for i := max(len(text)-len(secret)+1, 0); i < hold; i++ {
+			if strings.HasPrefix(secret, text[i:]) {
+				hold = i
+				break
+			}
+		}
+	}
+	return hold
+}
+
+func (r *Redactor) redactPrivateKeys(chunk string) (result string) {
+	for chunk != "" {
+		if r.inPrivateKey {
+			loc := privateKeyEnd.FindStringIndex(chunk)
+			if loc == nil {
+				if r.privateKeyBytes += len(chunk); r.privateKeyBytes > PRIVATE_KEY_MAX {
+					// end marker is missing: stop suppressing the following data
+					r.inPrivateKey = false
+					result += "\n(private key end not found)\n"
+				}
+				return result
+			}
+			result += chunk[loc[0]:loc[1]]
+			chunk = chunk[loc[1]:]
+			r.inPrivateKey = false
+		} else {
+			loc := privateKeyBegin.FindStringIndex(chunk)
+			if loc == nil {
+				return result + chunk
+			}
+			result += chunk[:loc[1]] + "\n" + MASK + "\n"
+			chunk = chunk[loc[1]:]
+			r.inPrivateKey = true
+			r.privateKeyBytes = 0
+		}
+	}
+	return result
+}
+
+// Redact a output stream. Data held back is flushed when in is idle for FLUSH_DELAY or closed.
+// If enabled returns false, chunks are passed through as is. Empty results are not sent to out
+func (r *Redactor) Stream(in <-chan string, enabled func() bool) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		send := func(data string) {
+			if data != "" {
+				out <- data
+			}
+		}
+		var idle <-chan time.Time
+		for {
+			select {
+			case data, ok := <-in:
+				if !ok {
+					send(r.Flush())
+					return
+				}
+				if enabled() {
+					send(r.Redact(data))
+				} else {
+					send(r.Flush() + data)
+				}
+				idle = nil
+				if r.hasPending() {
+					idle = time.After(FLUSH_DELAY)
+				}
+			case <-idle:
+				idle = nil
+				send(r.Flush())
+			}
+		}
+	}()
+	return out
+}
+
+func (r *Redactor) hasPending() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pending != ""
+}
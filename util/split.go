@@ -0,0 +1,343 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// A writer which splits the written stream into part files of at most Size bytes in a temp dir.
+// OnPart is called with the final name and the temp path of each part once it's complete;
+// the part file is removed after OnPart returns. If the stream fits in a single part,
+// it's named Name; otherwise parts are named Name.001, Name.002, ...
+// The first part is held back until it's known whether the stream is split.
+// Checksums are calculated along the way, see Manifest.
+type SplitWriter struct {
+	Name      string
+	Size      int64
+	OnPart    func(name string, file string) error
+	dir       string
+	file      *os.File
+	index     int
+	n         int64
+	held      string // temp path of the completed first part
+	closed    bool
+	hash      hash.Hash // of current part
+	wholeHash hash.Hash
+	manifest  SplitManifest
+}
+
+func NewSplitWriter(name string, size int64, onPart func(name string, file string) error) (*SplitWriter, error) {
+	dir, err := os.MkdirTemp("", "tgshell-split-")
+	if err != nil {
+		return nil, err
+	}
+	return &SplitWriter{Name: name, Size: size, OnPart: onPart, dir: dir,
+		wholeHash: sha256.New(), manifest: SplitManifest{Name: name}}, nil
+}
+
+func (sw *SplitWriter) emit(name string, file string) error {
+	defer os.Remove(file)
+	return sw.OnPart(name, file)
+}
+
+// Finish current part file
+func (sw *SplitWriter) finishPart() error {
+	file := sw.file.Name()
+	err := sw.file.Close()
+	sw.file = nil
+	if err != nil {
+		return err
+	}
+	sw.manifest.Parts = append(sw.manifest.Parts, SplitPart{
+		Name:   SplitPartName(sw.Name, sw.index),
+		Size:   sw.n,
+		Sha256: hex.EncodeToString(sw.hash.Sum(nil)),
+	})
+	if sw.index == 1 {
+		sw.held = file
+		return nil
+	}
+	return sw.emit(SplitPartName(sw.Name, sw.index), file)
+}
+
+func (sw *SplitWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if sw.file == nil {
+			if sw.held != "" {
+				if err := sw.emit(SplitPartName(sw.Name, 1), sw.held); err != nil {
+					return written, err
+				}
+				sw.held = ""
+			}
+			sw.index++
+			file, err := os.Create(filepath.Join(sw.dir, fmt.Sprint(sw.index)))
+			if err != nil {
+				return written, err
+			}
+			sw.file = file
+			sw.n = 0
+			sw.hash = sha256.New()
+		}
+		chunk := p
+		if int64(len(chunk)) > sw.Size-sw.n {
+			chunk = chunk[:sw.Size-sw.n]
+		}
+		n, err := sw.file.Write(chunk)
+		sw.hash.Write(chunk[:n])
+		sw.wholeHash.Write(chunk[:n])
+		written += n
+		sw.n += int64(n)
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+		if sw.n >= sw.Size {
+			if err := sw.finishPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Number of parts created so far
+func (sw *SplitWriter) Parts() int {
+	return sw.index
+}
+
+// Manifest of the written stream. Only valid after Close
+func (sw *SplitWriter) Manifest() *SplitManifest {
+	manifest := sw.manifest
+	manifest.Parts = slices.Clone(sw.manifest.Parts)
+	manifest.Sha256 = hex.EncodeToString(sw.wholeHash.Sum(nil))
+	for _, part := range manifest.Parts {
+		manifest.Size += part.Size
+	}
+	if len(manifest.Parts) == 1 {
+		manifest.Parts[0].Name = sw.Name
+	}
+	return &manifest
+}
+
+// Flush the last part and remove the temp dir
+func (sw *SplitWriter) Close() (err error) {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	defer os.RemoveAll(sw.dir)
+	if sw.file != nil {
+		if err = sw.finishPart(); err != nil {
+			return err
+		}
+	}
+	if sw.held != "" {
+		name := sw.Name
+		if sw.index > 1 {
+			name = SplitPartName(sw.Name, 1)
+		}
+		err = sw.emit(name, sw.held)
+		sw.held = ""
+	}
+	return err
+}
+
+// Discard all remaining part files without emitting them
+func (sw *SplitWriter) Abort() {
+	sw.closed = true
+	if sw.file != nil {
+		sw.file.Close()
+		sw.file = nil
+	}
+	os.RemoveAll(sw.dir)
+}
+
+const SPLIT_MANIFEST_SUFFIX = ".manifest.json"
+
+var splitPartRegexp = regexp.MustCompile(`^(.+)\.(\d{3})$`)
+
+// Manifest of a file split into parts, used to verify and reassemble them
+type SplitManifest struct {
+	Name   string      `json:"name"`
+	Size   int64       `json:"size"`
+	Sha256 string      `json:"sha256"`
+	Parts  []SplitPart `json:"parts"`
+}
+
+type SplitPart struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// Name of the index-th (1-based) part of name
+func SplitPartName(name string, index int) string {
+	return fmt.Sprintf("%s.%03d", name, index)
+}
+
+// Parse a part filename like "foo.zip.001". Return the original name and 1-based index
+func ParseSplitPartName(filename string) (name string, index int, ok bool) {
+	m := splitPartRegexp.FindStringSubmatch(filename)
+	if m == nil {
+		return "", 0, false
+	}
+	index, _ = strconv.Atoi(m[2])
+	return m[1], index, index > 0
+}
+
+// Read the manifest file of name in dir
+func ReadSplitManifest(dir string, name string) (*SplitManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name+SPLIT_MANIFEST_SUFFIX))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &SplitManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Name != name || filepath.Base(name) != name || len(manifest.Parts) == 0 {
+		return nil, fmt.Errorf("invalid manifest of %s", name)
+	}
+	// part names are used as paths in dir: only accept the expected names
+	for i, part := range manifest.Parts {
+		if part.Name != SplitPartName(name, i+1) || filepath.Base(part.Name) != part.Name {
+			return nil, fmt.Errorf("invalid manifest of %s: unexpected part name %q", name, part.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// Return hex SHA-256 of file
+func FileSha256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Verify a part file in dir against manifest
+func (m *SplitManifest) VerifyPart(dir string, index int) error {
+	part := m.Parts[index-1]
+	file := filepath.Join(dir, part.Name)
+	stat, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if stat.Size() != part.Size {
+		return fmt.Errorf("size of %s mismatch: %d != %d", part.Name, stat.Size(), part.Size)
+	}
+	if sum, err := FileSha256(file); err != nil {
+		return err
+	} else if sum != part.Sha256 {
+		return fmt.Errorf("SHA-256 of %s mismatch", part.Name)
+	}
+	return nil
+}
+
+// Return 1-based indexes of parts which do not exist in dir yet
+func (m *SplitManifest) MissingParts(dir string) (missing []int) {
+	for i, part := range m.Parts {
+		if _, err := os.Stat(filepath.Join(dir, part.Name)); err != nil {
+			missing = append(missing, i+1)
+		}
+	}
+	return missing
+}
+
+// Concatenate all parts in dir into dir/<name>, verifying checksums of each part and the result.
+// Parts and manifest are removed after success
+func (m *SplitManifest) Join(dir string) (string, error) {
+	dst := filepath.Join(dir, filepath.Base(m.Name))
+	if _, err := os.Lstat(dst); err == nil {
+		return "", fmt.Errorf("%s already exists", dst)
+	}
+	out, err := os.CreateTemp(dir, "."+filepath.Base(m.Name)+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(out.Name()) // no-op after rename
+	hash := sha256.New()
+	w := io.MultiWriter(out, hash)
+	for i, part := range m.Parts {
+		if err := m.VerifyPart(dir, i+1); err != nil {
+			out.Close()
+			return "", err
+		}
+		if err := copyFileTo(w, filepath.Join(dir, part.Name)); err != nil {
+			out.Close()
+			return "", err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != m.Sha256 {
+		return "", fmt.Errorf("SHA-256 of reassembled %s mismatch", m.Name)
+	}
+	if err := os.Rename(out.Name(), dst); err != nil {
+		return "", err
+	}
+	for _, part := range m.Parts {
+		os.Remove(filepath.Join(dir, part.Name))
+	}
+	os.Remove(filepath.Join(dir, m.Name+SPLIT_MANIFEST_SUFFIX))
+	return dst, nil
+}
+
+func copyFileTo(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Create the manifest of splitting file into parts of at most size bytes
+func NewSplitManifest(file string, size int64) (*SplitManifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifest := &SplitManifest{Name: filepath.Base(file)}
+	wholeHash := sha256.New()
+	for index := 1; ; index++ {
+		partHash := sha256.New()
+		n, err := io.Copy(io.MultiWriter(partHash, wholeHash), io.LimitReader(f, size))
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 && index > 1 {
+			break
+		}
+		manifest.Size += n
+		manifest.Parts = append(manifest.Parts, SplitPart{
+			Name:   SplitPartName(manifest.Name, index),
+			Size:   n,
+			Sha256: hex.EncodeToString(partHash.Sum(nil)),
+		})
+		if n < size {
+			break
+		}
+	}
+	manifest.Sha256 = hex.EncodeToString(wholeHash.Sum(nil))
+	return manifest, nil
+}
@@ -0,0 +1,183 @@
+package sshutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/armon/go-socks5"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/sagan/tgshell/util"
+)
+
+// A port forward over a ssh client, with traffic statistics.
+// Unlike the forwards of sshlib, it can be closed independently from the ssh client.
+type Forward struct {
+	Spec        string       // the original flag, e.g.: "-L 8080:127.0.0.1:80"
+	Connections atomic.Int64 // total forwarded connections
+	Active      atomic.Int64 // currently open connections
+	BytesOut    atomic.Int64 // bytes sent to forward target
+	BytesIn     atomic.Int64 // bytes received from forward target
+	listener    net.Listener
+	closeOnce   sync.Once
+}
+
+// net.Conn of forward target side which counts traffic to the Forward
+type countingConn struct {
+	net.Conn
+	forward *Forward
+}
+
+// socks5Resolver prevents DNS from resolving on the local machine.
+type socks5Resolver struct{}
+
+func (socks5Resolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	return ctx, nil, nil
+}
+
+func (c *countingConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	c.forward.BytesIn.Add(int64(n))
+	return
+}
+
+func (c *countingConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	c.forward.BytesOut.Add(int64(n))
+	return
+}
+
+func (c *countingConn) Close() error {
+	err := c.Conn.Close()
+	c.forward.Active.Add(-1)
+	return err
+}
+
+func (f *Forward) wrap(target net.Conn) net.Conn {
+	f.Connections.Add(1)
+	f.Active.Add(1)
+	return &countingConn{Conn: target, forward: f}
+}
+
+// serve accepts connections from listener and pipes each of them to dial()
+func (f *Forward) serve(dial func() (net.Conn, error)) {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			target, err := dial()
+			if err != nil {
+				return
+			}
+			target = f.wrap(target)
+			defer target.Close()
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				io.Copy(target, conn)
+				wg.Done()
+			}()
+			go func() {
+				io.Copy(conn, target)
+				wg.Done()
+			}()
+			wg.Wait()
+		}(conn)
+	}
+}
+
+// serveSocks5 runs a socks5 server on listener, which uses dial to connect to the requested address
+func (f *Forward) serveSocks5(dial func(network, addr string) (net.Conn, error)) error {
+	server, err := socks5.New(&socks5.Config{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			target, err := dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return f.wrap(target), nil
+		},
+		Resolver: socks5Resolver{},
+	})
+	if err != nil {
+		return err
+	}
+	go server.Serve(f.listener)
+	return nil
+}
+
+// Stop listening. Already forwarded connections are not affected.
+func (f *Forward) Close() error {
+	var err error
+	f.closeOnce.Do(func() {
+		err = f.listener.Close()
+	})
+	return err
+}
+
+// One line stats summary, e.g.: "-L 8080:127.0.0.1:80 : 3 conns (1 active), ↑1.2KiB ↓3.4MiB"
+func (f *Forward) String() string {
+	return fmt.Sprintf("%s : %d conns (%d active), ↑%s ↓%s", f.Spec, f.Connections.Load(), f.Active.Load(),
+		util.BytesSize(float64(f.BytesOut.Load())), util.BytesSize(float64(f.BytesIn.Load())))
+}
+
+// Like "ssh -L localAddr:remoteAddr". Listen on local localAddr, forward connections to remoteAddr via client.
+func LocalForward(client *ssh.Client, spec, localAddr, remoteAddr string) (*Forward, error) {
+	listener, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	f := &Forward{Spec: spec, listener: listener}
+	go f.serve(func() (net.Conn, error) {
+		return client.Dial("tcp", remoteAddr)
+	})
+	return f, nil
+}
+
+// Like "ssh -R remoteAddr:localAddr". Listen on remoteAddr of ssh server, forward connections to local localAddr.
+func RemoteForward(client *ssh.Client, spec, remoteAddr, localAddr string) (*Forward, error) {
+	listener, err := client.Listen("tcp", remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	f := &Forward{Spec: spec, listener: listener}
+	go f.serve(func() (net.Conn, error) {
+		return net.Dial("tcp", localAddr)
+	})
+	return f, nil
+}
+
+// Like "ssh -D addr". Run a local socks5 proxy server on addr, which connects to destinations via client.
+func DynamicForward(client *ssh.Client, spec, addr string) (*Forward, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	f := &Forward{Spec: spec, listener: listener}
+	if err := f.serveSocks5(client.Dial); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Like OpenSSH "ssh -R [bind_address:]port". Run a socks5 proxy server on addr of ssh server,
+// which connects to destinations from local.
+func ReverseDynamicForward(client *ssh.Client, spec, addr string) (*Forward, error) {
+	listener, err := client.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	f := &Forward{Spec: spec, listener: listener}
+	if err := f.serveSocks5(net.Dial); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return f, nil
+}
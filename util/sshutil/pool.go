@@ -0,0 +1,166 @@
+package sshutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blacknon/go-sshlib"
+	"golang.org/x/crypto/ssh"
+)
+
+// A ssh client which may be shared by multiple executor sessions (like OpenSSH ControlMaster).
+// Each user opens it's own session channels on it. The underlying connection is reference-counted
+// and closed when the last user releases it. A single keepalive loop runs per connection; when the
+// connection is lost, it's evicted from the pool and Done() is closed, so that every user can release it.
+type SharedClient struct {
+	*ssh.Client
+	key      string // empty if not shared
+	refs     int
+	aliveAt  atomic.Int64 // unix timestamp of last replied keepalive
+	done     chan struct{}
+	doneOnce sync.Once
+	err      error // why the connection is lost. Set before done is closed
+}
+
+var (
+	clientPool     = map[string]*SharedClient{}
+	clientPoolLock sync.Mutex
+)
+
+// Return the pool key of a connection: (user, host, port, auth)
+func clientKey(c *sshlib.Connect, host, port, user, pass string, identityFiles []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%t\n%s", pass, c.CheckKnownHosts, strings.Join(identityFiles, "\n"))
+	return fmt.Sprintf("%s@%s:%s#%s", user, host, port, hex.EncodeToString(h.Sum(nil)))
+}
+
+// Get a ssh client connected to user@host:port. If shared is true, reuse the existing client
+// of same (user, host, port, auth) if any. Caller must call Release() when no longer uses it.
+// A new client sends keepalive requests every aliveInterval seconds (0 to disable), and is considered
+// lost after aliveMax continuous failures. A reused client keeps the keepalive settings of it's creator
+func AcquireSshClient(c *sshlib.Connect, host, port, user, pass string, identityFiles []string,
+	shared bool, aliveInterval int, aliveMax int) (*SharedClient, error) {
+	key := ""
+	if shared {
+		key = clientKey(c, host, port, user, pass, identityFiles)
+		clientPoolLock.Lock()
+		if sc := clientPool[key]; sc != nil {
+			sc.refs++
+			clientPoolLock.Unlock()
+			log.Printf("ssh reuse connection %s@%s:%s (%d users)", user, host, port, sc.refs)
+			return sc, nil
+		}
+		clientPoolLock.Unlock()
+	}
+	if err := CreateSshClient(c, host, port, user, pass, identityFiles); err != nil {
+		return nil, err
+	}
+	sc := &SharedClient{Client: c.Client, key: key, refs: 1, done: make(chan struct{})}
+	if shared {
+		clientPoolLock.Lock()
+		// another user may have connected in the meantime
+		if existing := clientPool[key]; existing != nil {
+			existing.refs++
+			clientPoolLock.Unlock()
+			sc.Client.Close()
+			return existing, nil
+		}
+		clientPool[key] = sc
+		clientPoolLock.Unlock()
+	}
+	go func() {
+		// the connection may be broken by network
+		err := sc.Client.Wait()
+		sc.lost(fmt.Errorf("connection closed: %v", err))
+	}()
+	if aliveInterval > 0 {
+		go sc.keepalive(aliveInterval, aliveMax)
+	}
+	return sc, nil
+}
+
+// Mark the connection as lost: evict it from pool and notify users
+func (sc *SharedClient) lost(err error) {
+	sc.doneOnce.Do(func() {
+		clientPoolLock.Lock()
+		if sc.key != "" && clientPool[sc.key] == sc {
+			delete(clientPool, sc.key)
+		}
+		clientPoolLock.Unlock()
+		sc.err = err
+		close(sc.done)
+	})
+}
+
+// Send keepalive requests every interval seconds until the connection is lost.
+// The connection is considered lost if max continuous requests failed
+func (sc *SharedClient) keepalive(interval int, max int) {
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	i := 0
+	for {
+		select {
+		case <-sc.done:
+			return
+		case <-ticker.C:
+		}
+		if _, _, err := sc.Client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+			i = 0
+			sc.aliveAt.Store(time.Now().Unix())
+		} else {
+			i += 1
+		}
+		if max <= i {
+			sc.lost(fmt.Errorf("keepalive failed %d times", i))
+			return
+		}
+	}
+}
+
+// Closed when the connection is lost (closed or keepalive failed). Users should then release it
+func (sc *SharedClient) Done() <-chan struct{} {
+	return sc.done
+}
+
+// Why the connection is lost. Only valid after Done() is closed
+func (sc *SharedClient) Err() error {
+	return sc.err
+}
+
+// Time of last replied keepalive. Zero if none
+func (sc *SharedClient) AliveAt() time.Time {
+	if aliveAt := sc.aliveAt.Load(); aliveAt > 0 {
+		return time.Unix(aliveAt, 0)
+	}
+	return time.Time{}
+}
+
+// Current users count of the client
+func (sc *SharedClient) Refs() int {
+	clientPoolLock.Lock()
+	defer clientPoolLock.Unlock()
+	return sc.refs
+}
+
+// Decrease reference count. Close the connection if it's no longer used by anyone
+func (sc *SharedClient) Release() {
+	clientPoolLock.Lock()
+	defer clientPoolLock.Unlock()
+	if sc.refs <= 0 {
+		return
+	}
+	sc.refs--
+	if sc.refs > 0 {
+		return
+	}
+	if sc.key != "" && clientPool[sc.key] == sc {
+		delete(clientPool, sc.key)
+	}
+	sc.Client.Close()
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 )
 
 type fileIdRequest struct {
@@ -19,39 +20,61 @@ type fileIdRequest struct {
 	} `json:"result"`
 }
 
-// downloading file from url, then send it to telegram bot
-func DownloadTgFileToLocal(ctx context.Context, tgtoken string, tgFileId string, filepath string) error {
+// Open a file sent to telegram bot for reading. Caller must close it.
+// apiUrl is the base url of Bot API server. If the server runs in --local mode on the same host,
+// it returns an absolute local file path, which is read directly
+func OpenTgFile(ctx context.Context, apiUrl string, tgtoken string, tgFileId string) (io.ReadCloser, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf(
-		"https://api.telegram.org/bot%s/getFile?file_id=%s", tgtoken, tgFileId), nil)
+		"%s/bot%s/getFile?file_id=%s", apiUrl, tgtoken, tgFileId), nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request for tg file meta: %v", err)
+		return nil, fmt.Errorf("failed to create request for tg file meta: %v", err)
 	}
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to fetch tg file meta: %v", err)
+		return nil, fmt.Errorf("failed to fetch tg file meta: %v", err)
 	}
 	defer res.Body.Close()
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("failed to fetch tg file meta data: %v", err)
+		return nil, fmt.Errorf("failed to fetch tg file meta data: %v", err)
 	}
 	var responseTeleFile fileIdRequest
 	err = json.Unmarshal(body, &responseTeleFile)
 	if err != nil {
-		return fmt.Errorf("failed to parse tg file meta: %v", err)
+		return nil, fmt.Errorf("failed to parse tg file meta: %v", err)
+	}
+	if !responseTeleFile.Ok {
+		return nil, fmt.Errorf("failed to fetch tg file meta: %s", body)
+	}
+	if filepath.IsAbs(responseTeleFile.Result.FilePath) {
+		return os.Open(responseTeleFile.Result.FilePath)
 	}
 
-	resp, err := http.Get(fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", tgtoken,
-		responseTeleFile.Result.FilePath))
+	req, err = http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/file/bot%s/%s", apiUrl, tgtoken,
+		responseTeleFile.Result.FilePath), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for tg file: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to fetch tg file: %v", err)
+		return nil, fmt.Errorf("failed to fetch tg file: %v", err)
 	}
-	defer resp.Body.Close()
-	out, err := os.Create(filepath)
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch tg file: status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// downloading file from url, then send it to telegram bot
+func DownloadTgFileToLocal(ctx context.Context, apiUrl string, tgtoken string, tgFileId string, filepath string) error {
+	reader, err := OpenTgFile(ctx, apiUrl, tgtoken, tgFileId)
 	if err != nil {
-		return fmt.Errorf("failed to create local file '%s': %v", filepath, err)
+		return err
+	}
+	defer reader.Close()
+	if err := WriteFileAtomic(filepath, reader, 0644); err != nil {
+		return fmt.Errorf("failed to save local file '%s': %v", filepath, err)
 	}
-	defer out.Close()
-	_, err = io.Copy(out, resp.Body)
-	return err
+	return nil
 }
@@ -71,6 +71,7 @@ func ContextWithCancelSign(ctx context.Context, sign <-chan struct{}) (context.C
 			case <-sign:
 				cancel()
 			case <-ctx.Done():
+				return
 			}
 		}
 	}(ctx, sign, cancel)